@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/models"
+	auditService "lesson-proj/internal/services/audit"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// importedUser is one account from the old system
+type importedUser struct {
+	Email         string `json:"email"`
+	Name          string `json:"name"`
+	PasswordHash  string `json:"password_hash"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+	// line in the input file, for the report
+	line int
+}
+
+// importUsers creates accounts from a CSV or JSONL export of another system, keeping their
+// password hashes (bcrypt, PBKDF2, scrypt, see authUtils.ImportPasswordHash). Users sign in
+// with their old password and the hash is replaced with Argon2id on that first login.
+// Without -apply every record is only checked. Invalid records and emails that are
+// already registered are skipped and reported, so a fixed file can be imported again.
+func importUsers(ctx context.Context, userRepository *database.UserRepository, audit *auditService.AuditService, args []string) error {
+	flags := flag.NewFlagSet("import-users", flag.ExitOnError)
+	path := flags.String("file", "", "CSV (with a header row) or JSONL file")
+	format := flags.String("format", "", "csv or jsonl (default: from the file extension)")
+	apply := flags.Bool("apply", false, "create the accounts (default: check only)")
+	flags.Parse(args)
+
+	if *path == "" {
+		return errors.New("-file is required")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*path)), ".")
+	}
+	file, err := os.Open(*path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var users []importedUser
+	switch *format {
+	case "csv":
+		users, err = readImportCSV(file)
+	case "jsonl", "ndjson":
+		users, err = readImportJSONL(file)
+	default:
+		return fmt.Errorf("unknown format %q, use -format csv or -format jsonl", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]int)
+	created, skipped, invalid := 0, 0, 0
+	for _, user := range users {
+		if user.Role == "" {
+			user.Role = models.RoleUser
+		}
+		if err := authUtils.ValidateImportedUser(user.Email, user.Name, user.PasswordHash); err != nil {
+			fmt.Printf("line %d: invalid: %v\n", user.line, err)
+			invalid++
+			continue
+		}
+		if err := authUtils.ValidateRole(user.Role); err != nil {
+			fmt.Printf("line %d: invalid: %v\n", user.line, err)
+			invalid++
+			continue
+		}
+		email, err := authUtils.NormalizeEmail(user.Email)
+		if err != nil {
+			return err
+		}
+		if firstLine, duplicate := seen[email]; duplicate {
+			fmt.Printf("line %d: invalid: %s is already on line %d\n", user.line, email, firstLine)
+			invalid++
+			continue
+		}
+		seen[email] = user.line
+		storedHash, err := authUtils.ImportPasswordHash(user.PasswordHash)
+		if err != nil {
+			return err
+		}
+		if !*apply {
+			continue
+		}
+
+		createdUser, err := userRepository.CreateUser(ctx, models.CreateUser{
+			Email:    email,
+			Name:     strings.TrimSpace(user.Name),
+			Password: storedHash,
+			Role:     user.Role,
+		})
+		if errors.Is(err, database.ErrAlreadyExists) {
+			fmt.Printf("line %d: skipped: %s is already registered\n", user.line, email)
+			skipped++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", user.line, err)
+		}
+		if user.EmailVerified {
+			if err := userRepository.MarkEmailVerified(ctx, createdUser.ID); err != nil {
+				return fmt.Errorf("line %d: %w", user.line, err)
+			}
+		}
+		audit.Record(ctx, models.AuditEvent{
+			Type:       models.AuditUserImported,
+			TargetType: models.AuditTargetUser,
+			TargetID:   &createdUser.ID,
+			UserAgent:  "cmd/admin",
+			Details:    map[string]any{"hash_scheme": authUtils.HashScheme(storedHash)},
+		}, nil)
+		created++
+	}
+
+	if *apply {
+		fmt.Printf("records: %d, imported: %d, already registered: %d, invalid: %d\n", len(users), created, skipped, invalid)
+	} else {
+		fmt.Printf("records: %d, valid: %d, invalid: %d (check only, run with -apply to import)\n", len(users), len(users)-invalid, invalid)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid records were not imported", invalid)
+	}
+	return nil
+}
+
+// readImportCSV reads a CSV file with the header email,name,password_hash[,role][,email_verified]
+// (any column order)
+func readImportCSV(reader io.Reader) ([]importedUser, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"email", "name", "password_hash"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("csv header has no %q column", required)
+		}
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var users []importedUser
+	for {
+		record, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			return users, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := csvReader.FieldPos(0)
+		user := importedUser{
+			Email:        field(record, "email"),
+			Name:         field(record, "name"),
+			PasswordHash: field(record, "password_hash"),
+			Role:         field(record, "role"),
+			line:         line,
+		}
+		if verified := field(record, "email_verified"); verified != "" {
+			user.EmailVerified, err = strconv.ParseBool(verified)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid email_verified %q", line, verified)
+			}
+		}
+		users = append(users, user)
+	}
+}
+
+// readImportJSONL reads one JSON object per line, empty lines are ignored
+func readImportJSONL(reader io.Reader) ([]importedUser, error) {
+	scanner := bufio.NewScanner(reader)
+	var users []importedUser
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var user importedUser
+		if err := json.Unmarshal([]byte(text), &user); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		user.line = line
+		users = append(users, user)
+	}
+	return users, scanner.Err()
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/models"
+	auditService "lesson-proj/internal/services/audit"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Admin command line tool, run it from the repo root:
+//
+//	go run ./cmd/admin create-admin -email admin@example.com -name Admin
+func init() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	db, err := database.Connect(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := authUtils.ConfigureFromEnv(); err != nil {
+		log.Fatalf("Failed to configure password hashing: %v", err)
+	}
+
+	ctx := context.Background()
+	userRepository := database.NewUserRepository(db)
+	audit := auditService.NewAuditService(database.NewAuditEventRepository(db))
+
+	switch os.Args[1] {
+	case "create-admin":
+		err = createAdmin(ctx, userRepository, audit, os.Args[2:])
+	case "pepper-report":
+		err = pepperReport(ctx, userRepository)
+	case "normalize-emails":
+		err = normalizeEmails(ctx, userRepository, os.Args[2:])
+	case "import-users":
+		err = importUsers(ctx, userRepository, audit, os.Args[2:])
+	default:
+		printUsage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: admin <command> [flags]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  create-admin -email <email> [-name <name>]   create an admin or promote an existing user")
+	fmt.Fprintln(os.Stderr, "  pepper-report                                count users per password pepper")
+	fmt.Fprintln(os.Stderr, "  normalize-emails [-apply]                    normalize stored emails, report duplicates")
+	fmt.Fprintln(os.Stderr, "  import-users -file <csv|jsonl> [-apply]      import accounts with legacy password hashes")
+}
+
+// createAdmin is the bootstrap path for the first admin:
+// an existing user is promoted, otherwise a new admin account is created.
+// The password is read from ADMIN_PASSWORD or stdin so it never appears in shell history.
+func createAdmin(ctx context.Context, userRepository *database.UserRepository, audit *auditService.AuditService, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := flags.String("email", "", "admin email")
+	name := flags.String("name", "Admin", "admin name (only for a new account)")
+	flags.Parse(args)
+
+	if strings.TrimSpace(*email) == "" {
+		return errors.New("-email is required")
+	}
+	normalizedEmail, err := authUtils.NormalizeEmail(*email)
+	if err != nil {
+		return err
+	}
+	*email = normalizedEmail
+
+	existingUser, err := userRepository.GetUserByEmail(ctx, *email)
+	if err == nil {
+		if _, err := userRepository.UpdateUserRole(ctx, existingUser.ID, models.RoleAdmin); err != nil {
+			return err
+		}
+		recordBootstrap(ctx, audit, existingUser.ID, "promoted")
+		log.Printf("User %s (id %d) promoted to admin", existingUser.Email, existingUser.ID)
+		return nil
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		return err
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	if err := authUtils.ValidateCreateUserInput(*email, *name, password); err != nil {
+		return err
+	}
+	hashPassword, err := authUtils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	createdUser, err := userRepository.CreateUser(ctx, models.CreateUser{
+		Email:    *email,
+		Name:     *name,
+		Password: hashPassword,
+		Role:     models.RoleAdmin,
+	})
+	if err != nil {
+		return err
+	}
+	// the operator vouches for the address, no verification email for the bootstrap admin
+	if err := userRepository.MarkEmailVerified(ctx, createdUser.ID); err != nil {
+		return err
+	}
+	recordBootstrap(ctx, audit, createdUser.ID, "created")
+	log.Printf("Admin %s created with id %d", createdUser.Email, createdUser.ID)
+	return nil
+}
+
+// recordBootstrap audits a CLI promotion, there is no actor: whoever runs this has database access
+func recordBootstrap(ctx context.Context, audit *auditService.AuditService, userID int, action string) {
+	audit.Record(ctx, models.AuditEvent{
+		Type:       models.AuditAdminBootstrap,
+		TargetType: models.AuditTargetUser,
+		TargetID:   &userID,
+		UserAgent:  "cmd/admin",
+		Details:    map[string]any{"action": action},
+	}, nil)
+}
+
+func readPassword() (string, error) {
+	if password := os.Getenv("ADMIN_PASSWORD"); password != "" {
+		return password, nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// pepperReport prints how many users have a hash made with each pepper.
+// A retired pepper can be removed from PASSWORD_PEPPERS once its count is 0
+// (hashes move to the current pepper on the next login of each user).
+// Imported legacy hashes have no pepper and are counted by scheme.
+func pepperReport(ctx context.Context, userRepository *database.UserRepository) error {
+	counts := make(map[string]int)
+	legacy := make(map[string]int)
+	invalid := 0
+	err := userRepository.ForEachPasswordHash(ctx, func(id int, hashedPassword string) error {
+		if scheme := authUtils.HashScheme(hashedPassword); scheme != authUtils.SchemeArgon2id && scheme != "" {
+			legacy[scheme]++
+			return nil
+		}
+		pepperID, err := authUtils.PepperID(hashedPassword)
+		if err != nil {
+			invalid++
+			return nil
+		}
+		counts[pepperID]++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	currentID := authUtils.CurrentPepperID()
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	retired := 0
+	for _, id := range ids {
+		status := "retired"
+		if id == currentID {
+			status = "current"
+		} else {
+			retired += counts[id]
+		}
+		fmt.Printf("pepper %-10s %-8s %d users\n", id, status, counts[id])
+	}
+	schemes := make([]string, 0, len(legacy))
+	for scheme := range legacy {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	for _, scheme := range schemes {
+		fmt.Printf("imported %-9s hashes: %d users (upgraded on their next login)\n", scheme, legacy[scheme])
+	}
+	if invalid > 0 {
+		fmt.Printf("unparseable hashes: %d users\n", invalid)
+	}
+	fmt.Printf("users on a retired pepper: %d\n", retired)
+	return nil
+}
+
+// normalizeEmails migrates a database created before emails were normalized.
+// Without -apply it only prints the report. With -apply, emails that normalize to a
+// unique address are rewritten, and once no duplicates remain the case-insensitive
+// unique index replaces the old constraint. Duplicates (Alice@x.com and alice@x.com)
+// and invalid addresses are never changed automatically: an operator decides which
+// account keeps the address (e.g. the verified one) and edits or deletes the others,
+// then runs the command again.
+func normalizeEmails(ctx context.Context, userRepository *database.UserRepository, args []string) error {
+	flags := flag.NewFlagSet("normalize-emails", flag.ExitOnError)
+	apply := flags.Bool("apply", false, "write the changes (default: report only)")
+	flags.Parse(args)
+
+	type account struct {
+		user       models.UserWithoutPassword
+		normalized string
+	}
+	groups := make(map[string][]account)
+	var (
+		keys    []string
+		invalid []models.UserWithoutPassword
+	)
+	err := userRepository.ForEachUserEmail(ctx, func(user models.UserWithoutPassword) error {
+		normalized, err := authUtils.NormalizeEmail(user.Email)
+		key := normalized
+		if err != nil {
+			invalid = append(invalid, user)
+			// still grouped, an invalid address can collide with a valid one by casing
+			key = strings.ToLower(strings.TrimSpace(user.Email))
+		}
+		if _, seen := groups[key]; !seen {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], account{user: user, normalized: normalized})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	updated, duplicates := 0, 0
+	for _, key := range keys {
+		accounts := groups[key]
+		if len(accounts) > 1 {
+			duplicates++
+			fmt.Printf("duplicate %s:\n", key)
+			for _, account := range accounts {
+				fmt.Printf("  user %-8d %-40s role=%-10s verified=%t\n",
+					account.user.ID, account.user.Email, account.user.Role, account.user.EmailVerified)
+			}
+			continue
+		}
+		account := accounts[0]
+		if account.normalized == "" || account.normalized == account.user.Email {
+			continue
+		}
+		fmt.Printf("user %-8d %s -> %s\n", account.user.ID, account.user.Email, account.normalized)
+		if *apply {
+			email := account.normalized
+			if err := userRepository.SetNormalizedEmail(ctx, account.user.ID, email); err != nil {
+				return fmt.Errorf("user %d: %w", account.user.ID, err)
+			}
+		}
+		updated++
+	}
+	for _, user := range invalid {
+		fmt.Printf("invalid email: user %d %q\n", user.ID, user.Email)
+	}
+
+	action := "to normalize"
+	if *apply {
+		action = "normalized"
+	}
+	fmt.Printf("emails %s: %d, duplicate groups: %d, invalid: %d\n", action, updated, duplicates, len(invalid))
+	if duplicates > 0 {
+		return fmt.Errorf("%d duplicate groups need manual resolution before the unique index can be created", duplicates)
+	}
+	if !*apply {
+		return nil
+	}
+	if err := userRepository.EnsureCaseInsensitiveEmails(ctx); err != nil {
+		return err
+	}
+	fmt.Println("case-insensitive unique index on users.email is in place")
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runPeriodically starts job in the background: once right away, then every interval
+// until the process exits. A failed run is logged and the job tries again on the next tick.
+// Jobs may run on several instances at once, so each must be safe to run concurrently.
+func runPeriodically(name string, interval time.Duration, job func(ctx context.Context) error) {
+	if interval <= 0 {
+		log.Fatalf("Invalid interval %s for job %s", interval, name)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			// a run never overlaps the next one on this instance
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := job(ctx); err != nil {
+				log.Printf("job %s failed: %v", name, err)
+			}
+			cancel()
+			<-ticker.C
+		}
+	}()
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"lesson-proj/internal/database"
 	"lesson-proj/internal/handlers"
-	authService "lesson-proj/internal/services/auth" 
+	"lesson-proj/internal/mailer"
+	auditService "lesson-proj/internal/services/audit"
+	authService "lesson-proj/internal/services/auth"
+	authUtils "lesson-proj/internal/services/auth/utils"
 	productService "lesson-proj/internal/services/products"
 	"log"
 	"net/http"
 	"os"
+	"runtime"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -34,25 +39,158 @@ func main() {
 
 	log.Println("Connected to the database successfully")
 
+	// security events of every service go to one append-only log
+	auditService := auditService.NewAuditService(database.NewAuditEventRepository(db))
+	auditHandler := handlers.NewAuditHandler(auditService)
+
 	productRepository := database.NewProductRepository(db)
-	productService := productService.NewProductService(productRepository)
+	productService := productService.NewProductService(productRepository, auditService)
 	handler := handlers.NewProductHandler(productService)
 
-	userRepository := database.NewUserRepository(db)
-	userService := authService.NewUserService(userRepository)
+	// Argon2 costs and peppers for new hashes, older hashes are upgraded on login
+	if err := authUtils.ConfigureFromEnv(); err != nil {
+		log.Fatalf("Failed to configure password hashing: %v", err)
+	}
+
+	// every Argon2 call takes ARGON2_MEMORY_KB of memory: at most HASH_WORKERS run at once,
+	// HASH_QUEUE_DEPTH more may wait, anything above gets 503
+	hashPool, err := authUtils.NewHashPool(
+		intFromEnv("HASH_WORKERS", runtime.NumCPU()),
+		intFromEnv("HASH_QUEUE_DEPTH", 64),
+	)
+	if err != nil {
+		log.Fatalf("Failed to configure password hashing pool: %v", err)
+	}
+
+	// access tokens are signed with an HMAC key from env
+	tokenManager, err := authUtils.NewTokenManager(
+		os.Getenv("ACCESS_TOKEN_SECRET"),
+		durationFromEnv("ACCESS_TOKEN_TTL", 15*time.Minute),
+		durationFromEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+	)
+	if err != nil {
+		log.Fatalf("Failed to configure tokens: %v", err)
+	}
+
+	// MAIL_DRIVER=smtp for real delivery, log prints emails for local development (required, no default)
+	accountMailer, err := mailer.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
+
+	// TOTP secrets are stored encrypted, without a key 2FA enrollment is disabled
+	var secretBox *authUtils.SecretBox
+	if key := os.Getenv("TOTP_ENCRYPTION_KEY"); key != "" {
+		secretBox, err = authUtils.NewSecretBox(key)
+		if err != nil {
+			log.Fatalf("Invalid TOTP_ENCRYPTION_KEY: %v", err)
+		}
+	} else {
+		log.Println("TOTP_ENCRYPTION_KEY is not set, two-factor authentication is disabled")
+	}
+	totpIssuer := os.Getenv("TOTP_ISSUER")
+	if totpIssuer == "" {
+		totpIssuer = "Messenger"
+	}
+
+	// external sign-in (OIDC_PROVIDERS, or OIDC_MOCK=true for local development)
+	oidcProviders, stopOIDCMock := loadOIDCProviders(context.Background())
+	defer stopOIDCMock()
+
+	userService := authService.NewUserService(authService.Dependencies{
+		Users:         database.NewUserRepository(db),
+		RefreshTokens: database.NewRefreshTokenRepository(db),
+		OneTimeTokens: database.NewOneTimeTokenRepository(db),
+		LoginAttempts: database.NewLoginAttemptRepository(db),
+		RecoveryCodes: database.NewRecoveryCodeRepository(db),
+		Identities:    database.NewIdentityRepository(db),
+		APIKeys:       database.NewAPIKeyRepository(db),
+		Logins:        database.NewLoginRepository(db),
+		Products:      productRepository,
+		Audit:         auditService,
+		Tokens:        tokenManager,
+		HashPool:      hashPool,
+		Mailer:        accountMailer,
+		AppBaseURL:    os.Getenv("APP_BASE_URL"),
+		SecretBox:     secretBox,
+		TOTPIssuer:    totpIssuer,
+		OIDCProviders: oidcProviders,
+		// passwordless sign-in links, optionally bound to the requesting IP / device
+		MagicLink: authService.MagicLinkOptions{
+			TTL:        durationFromEnv("MAGIC_LINK_TTL", 15*time.Minute),
+			BindIP:     boolFromEnv("MAGIC_LINK_BIND_IP", false),
+			BindDevice: boolFromEnv("MAGIC_LINK_BIND_DEVICE", false),
+		},
+		// DELETE /users/{id} is a soft delete, the account is erased after this unless restored
+		ErasureGracePeriod: durationFromEnv("ACCOUNT_ERASURE_GRACE_PERIOD", 30*24*time.Hour),
+	})
+
+	// erases accounts whose grace period is over
+	runPeriodically("account erasure", durationFromEnv("ERASURE_JOB_INTERVAL", time.Hour), func(ctx context.Context) error {
+		erased, err := userService.CompleteDueErasures(ctx)
+		if erased > 0 {
+			log.Printf("erased %d accounts", erased)
+		}
+		return err
+	})
+	// removes deleted products for good once they are older than the retention period
+	productRetention := durationFromEnv("DELETED_PRODUCT_RETENTION", 30*24*time.Hour)
+	runPeriodically("product retention", durationFromEnv("RETENTION_JOB_INTERVAL", time.Hour), func(ctx context.Context) error {
+		purged, err := productService.PurgeDeletedProducts(ctx, productRetention)
+		if purged > 0 {
+			log.Printf("purged %d deleted products", purged)
+		}
+		return err
+	})
 	userHandler := handlers.NewUserHandler(userService)
 
 	router := http.NewServeMux()
 	router.HandleFunc("/products", methodHandler(handler.GetAllProducts, http.MethodGet))
-	router.HandleFunc("/products/create", methodHandler(handler.CreateProduct, http.MethodPost))
+	router.HandleFunc("/products/create", methodHandler(requireAuthOrAPIKey(handler.CreateProduct), http.MethodPost))
 	router.HandleFunc("/products/", productIDHandler(handler))
 
-	router.HandleFunc("/users", methodHandler(userHandler.GetAllUsers, http.MethodGet))
+	router.HandleFunc("/audit-events", methodHandler(requireAuth(auditHandler.GetAuditEvents), http.MethodGet))
+	router.HandleFunc("/audit-events/export", methodHandler(requireAuth(auditHandler.ExportAuditEvents), http.MethodGet))
+
+	router.HandleFunc("/metrics/password-hashing", methodHandler(requireAuth(userHandler.HashPoolStats), http.MethodGet))
+
+	router.HandleFunc("/users", methodHandler(requireAuth(userHandler.GetAllUsers), http.MethodGet))
 	router.HandleFunc("/users/create", methodHandler(userHandler.Registration, http.MethodPost))
 	router.HandleFunc("/users/", userIDHandler(userHandler))
 	router.HandleFunc("/users/auth", methodHandler(userHandler.Authorization, http.MethodPost))
+	router.HandleFunc("/users/auth/refresh", methodHandler(userHandler.RefreshTokens, http.MethodPost))
+	router.HandleFunc("/users/auth/2fa", methodHandler(userHandler.CompleteTwoFactorLogin, http.MethodPost))
+	router.HandleFunc("/users/auth/magic-link", methodHandler(userHandler.RequestMagicLink, http.MethodPost))
+	router.HandleFunc("/users/auth/magic-link/redeem", methodHandler(userHandler.RedeemMagicLink, http.MethodPost))
+	router.HandleFunc("/users/auth/oidc/start", methodHandler(userHandler.StartOIDCLogin, http.MethodPost))
+	router.HandleFunc("/users/auth/oidc/callback", methodHandler(userHandler.CompleteOIDCLogin, http.MethodPost))
+	router.HandleFunc("/users/identities", methodHandler(requireAuth(userHandler.GetIdentities), http.MethodGet))
+	router.HandleFunc("/users/identities/", methodHandler(requireAuth(userHandler.UnlinkIdentity), http.MethodDelete))
+	router.HandleFunc("/users/identities/link", methodHandler(requireAuth(userHandler.StartOIDCLink), http.MethodPost))
+	router.HandleFunc("/users/identities/callback", methodHandler(requireAuth(userHandler.CompleteOIDCLink), http.MethodPost))
+	router.HandleFunc("/users/api-keys", apiKeysHandler(userHandler))
+	router.HandleFunc("/users/api-keys/", apiKeysHandler(userHandler))
+	router.HandleFunc("/users/2fa/enroll", methodHandler(requireAuth(userHandler.EnrollTwoFactor), http.MethodPost))
+	router.HandleFunc("/users/2fa/confirm", methodHandler(requireAuth(userHandler.ConfirmTwoFactor), http.MethodPost))
+	router.HandleFunc("/users/email/change", methodHandler(requireAuth(userHandler.RequestEmailChange), http.MethodPost))
+	router.HandleFunc("/users/email/confirm", methodHandler(userHandler.ConfirmEmailChange, http.MethodPost))
+	router.HandleFunc("/users/email/cancel", methodHandler(userHandler.CancelEmailChange, http.MethodPost))
+	router.HandleFunc("/users/verify", methodHandler(userHandler.VerifyEmail, http.MethodPost))
+	router.HandleFunc("/users/verify/resend", methodHandler(requireAuth(userHandler.ResendVerification), http.MethodPost))
+	router.HandleFunc("/users/password/forgot", methodHandler(userHandler.ForgotPassword, http.MethodPost))
+	router.HandleFunc("/users/password/reset", methodHandler(userHandler.ResetPassword, http.MethodPost))
+	router.HandleFunc("/users/restore", methodHandler(userHandler.RestoreDeletedAccount, http.MethodPost))
+	router.HandleFunc("/users/logout", methodHandler(userHandler.Logout, http.MethodPost))
+	router.HandleFunc("/users/me", meHandler(userHandler))
+	router.HandleFunc("/profiles/", methodHandler(requireAuth(userHandler.GetPublicProfileByHandle), http.MethodGet))
+	router.HandleFunc("/users/me/sessions", methodHandler(requireAuth(userHandler.GetSessions), http.MethodGet))
+	router.HandleFunc("/users/me/logins", methodHandler(requireAuth(userHandler.GetLogins), http.MethodGet))
+	router.HandleFunc("/users/me/export", methodHandler(requireAuth(userHandler.ExportUserData), http.MethodGet))
+	router.HandleFunc("/users/logout/all", methodHandler(requireAuth(userHandler.LogoutAll), http.MethodPost))
 
-	loggedRouter := loggingMiddleware(router)
+	authRouter := authMiddleware(userService, router)
+	clientRouter := clientMiddleware(authRouter)
+	loggedRouter := loggingMiddleware(clientRouter)
 	corsHandler := corsMiddleware(loggedRouter)
 
 	srv := &http.Server{
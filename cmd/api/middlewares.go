@@ -1,8 +1,14 @@
 package main
 
 import (
+	"errors"
+	"lesson-proj/internal/authctx"
+	"lesson-proj/internal/models"
+	authService "lesson-proj/internal/services/auth"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 )
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -15,8 +21,8 @@ func loggingMiddleware(next http.Handler) http.Handler {
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
 		response.Header().Set("Access-Control-Allow-Origin", "*")
-		response.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		response.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		response.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		response.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		if request.Method == "OPTIONS" {
 			response.WriteHeader(http.StatusOK)
 			return
@@ -24,3 +30,62 @@ func corsMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(response, request)
 	})
 }
+
+// clientMiddleware stores the client IP and user agent in the request context
+// (used for login throttling and security logs).
+// RemoteAddr is used as is: behind a reverse proxy, configure the proxy
+// to pass the real client address instead of trusting X-Forwarded-For here.
+func clientMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		ip, _, err := net.SplitHostPort(request.RemoteAddr)
+		if err != nil {
+			ip = request.RemoteAddr
+		}
+		ctx := authctx.WithClient(request.Context(), authctx.Client{
+			IP:        ip,
+			UserAgent: request.UserAgent(),
+		})
+		next.ServeHTTP(response, request.WithContext(ctx))
+	})
+}
+
+// authMiddleware validates "Authorization: Bearer <token>" and puts the caller
+// (user ID + role) into the request context. The token is an access token or a personal
+// API key ("mk_..."). Requests without the header pass through as anonymous,
+// routes that need a user are wrapped with requireAuth.
+func authMiddleware(userService *authService.UserService, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		header := request.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(response, request)
+			return
+		}
+
+		// expected format: "Bearer <token>"
+		scheme, token, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			http.Error(response, "Invalid authorization header", http.StatusUnauthorized)
+			return
+		}
+
+		token = strings.TrimSpace(token)
+		var principal *authctx.Principal
+		var err error
+		if strings.HasPrefix(token, models.APIKeyPrefix) {
+			principal, err = userService.AuthenticateAPIKey(request.Context(), token)
+		} else {
+			principal, err = userService.AuthenticateAccessToken(request.Context(), token)
+		}
+		if errors.Is(err, authService.ErrInvalidToken) {
+			http.Error(response, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(response, "Failed to authenticate request", http.StatusInternalServerError)
+			return
+		}
+
+		ctx := authctx.WithPrincipal(request.Context(), *principal)
+		next.ServeHTTP(response, request.WithContext(ctx))
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"lesson-proj/internal/oidc"
+	"lesson-proj/internal/oidc/mockprovider"
+	"log"
+	"os"
+	"strings"
+)
+
+// loadOIDCProviders connects to the providers from OIDC_PROVIDERS.
+// OIDC_MOCK=true also starts an in-process mock provider named "mock" for local development,
+// the returned function stops it. The mock signs in any email, so it also needs APP_ENV=development.
+func loadOIDCProviders(ctx context.Context) (map[string]*oidc.Provider, func()) {
+	providers := make(map[string]*oidc.Provider)
+	for _, config := range oidc.ConfigsFromEnv() {
+		provider, err := oidc.NewProvider(ctx, config)
+		if err != nil {
+			log.Fatalf("Failed to configure OIDC: %v", err)
+		}
+		providers[config.Name] = provider
+	}
+
+	stop := func() {}
+	if os.Getenv("OIDC_MOCK") == "true" {
+		if os.Getenv("APP_ENV") != "development" {
+			log.Fatal("OIDC_MOCK=true requires APP_ENV=development: the mock provider signs in any email")
+		}
+		mock, err := mockprovider.NewServer("mock-client", "mock-secret")
+		if err != nil {
+			log.Fatalf("Failed to start mock OIDC provider: %v", err)
+		}
+		redirectURL := os.Getenv("OIDC_MOCK_REDIRECT_URL")
+		if redirectURL == "" {
+			redirectURL = strings.TrimSuffix(os.Getenv("APP_BASE_URL"), "/") + "/oidc/callback"
+		}
+		provider, err := oidc.NewProvider(ctx, oidc.ProviderConfig{
+			Name:         "mock",
+			IssuerURL:    mock.URL,
+			ClientID:     mock.ClientID,
+			ClientSecret: mock.ClientSecret,
+			RedirectURL:  redirectURL,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure mock OIDC provider: %v", err)
+		}
+		providers["mock"] = provider
+		stop = mock.Close
+		log.Printf("Mock OIDC provider running at %s (do not enable in production)", mock.URL)
+	}
+	return providers, stop
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"lesson-proj/internal/authctx"
 	"lesson-proj/internal/handlers"
 	"net/http"
+	"strings"
 )
 
 func methodHandler(handlerFunc http.HandlerFunc, allowedMethod string) http.HandlerFunc {
@@ -15,15 +17,51 @@ func methodHandler(handlerFunc http.HandlerFunc, allowedMethod string) http.Hand
 	}
 }
 
+// requireAuth rejects anonymous requests and API keys (account management needs a session),
+// authMiddleware must run before it to fill the request context
+func requireAuth(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return requireAuthOrAPIKey(func(response http.ResponseWriter, request *http.Request) {
+		if principal, _ := authctx.PrincipalFromContext(request.Context()); principal.IsAPIKey() {
+			http.Error(response, "API keys cannot be used for this endpoint", http.StatusForbidden)
+			return
+		}
+		handlerFunc(response, request)
+	})
+}
+
+// requireAuthOrAPIKey rejects anonymous requests, API keys are accepted
+// (the service checks their scopes)
+func requireAuthOrAPIKey(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		if _, ok := authctx.UserID(request.Context()); !ok {
+			response.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(response, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		handlerFunc(response, request)
+	}
+}
+
 func productIDHandler(handlers *handlers.ProductHandler) http.HandlerFunc {
 	return func(response http.ResponseWriter, request *http.Request) {
+		// sub-resources: /products/{id}/<name>
+		switch getSubresourceFromPath(request) {
+		case "":
+		case "restore":
+			methodHandler(requireAuthOrAPIKey(handlers.RestoreProduct), http.MethodPost)(response, request)
+			return
+		default:
+			http.NotFound(response, request)
+			return
+		}
+
 		switch request.Method {
 		case http.MethodGet:
 			handlers.GetProductByID(response, request)
 		case http.MethodPut:
-			handlers.UpdateProduct(response, request)
+			requireAuthOrAPIKey(handlers.UpdateProduct)(response, request)
 		case http.MethodDelete:
-			handlers.DeleteProduct(response, request)
+			requireAuthOrAPIKey(handlers.DeleteProduct)(response, request)
 		default:
 			http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
 		}
@@ -31,15 +69,87 @@ func productIDHandler(handlers *handlers.ProductHandler) http.HandlerFunc {
 }
 func userIDHandler(handlers *handlers.UserHandler) http.HandlerFunc {
 	return func(response http.ResponseWriter, request *http.Request) {
+		// sub-resources: /users/{id}/<name>
+		switch getSubresourceFromPath(request) {
+		case "":
+		case "password":
+			methodHandler(requireAuth(handlers.ChangePassword), http.MethodPost)(response, request)
+			return
+		case "unlock":
+			methodHandler(requireAuth(handlers.UnlockAccount), http.MethodPost)(response, request)
+			return
+		case "role":
+			methodHandler(requireAuth(handlers.UpdateUserRole), http.MethodPut)(response, request)
+			return
+		case "2fa":
+			methodHandler(requireAuth(handlers.ResetTwoFactor), http.MethodDelete)(response, request)
+			return
+		case "profile":
+			methodHandler(requireAuth(handlers.GetPublicProfile), http.MethodGet)(response, request)
+			return
+		case "deactivate":
+			methodHandler(requireAuth(handlers.DeactivateUser), http.MethodPost)(response, request)
+			return
+		case "restore":
+			methodHandler(requireAuth(handlers.RestoreUser), http.MethodPost)(response, request)
+			return
+		default:
+			http.NotFound(response, request)
+			return
+		}
+
 		switch request.Method {
 		case http.MethodGet:
-			handlers.GetUserByID(response, request)
+			requireAuth(handlers.GetUserByID)(response, request)
 		case http.MethodPut:
-			handlers.UpdateUser(response, request)
+			requireAuth(handlers.UpdateUser)(response, request)
 		case http.MethodDelete:
-			handlers.DeleteUser(response, request)
+			requireAuth(handlers.DeleteUser)(response, request)
 		default:
 			http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
 		} 
 	}
 }
+
+// getSubresourceFromPath returns the part after the ID:
+// "/users/5/role" -> "role", "/users/5" -> ""
+func getSubresourceFromPath(request *http.Request) string {
+	// ["", "users", "5", "role"]
+	pathParts := strings.Split(strings.TrimSuffix(request.URL.Path, "/"), "/")
+	if len(pathParts) < 4 {
+		return ""
+	}
+	return strings.Join(pathParts[3:], "/")
+}
+
+// meHandler serves the caller's own profile on /users/me
+func meHandler(handlers *handlers.UserHandler) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			requireAuth(handlers.GetProfile)(response, request)
+		case http.MethodPatch:
+			requireAuth(handlers.UpdateProfile)(response, request)
+		default:
+			http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// apiKeysHandler serves /users/api-keys (list, create) and /users/api-keys/{id} (revoke)
+func apiKeysHandler(handlers *handlers.UserHandler) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		if strings.TrimSuffix(request.URL.Path, "/") != "/users/api-keys" {
+			methodHandler(requireAuth(handlers.RevokeAPIKey), http.MethodDelete)(response, request)
+			return
+		}
+		switch request.Method {
+		case http.MethodGet:
+			requireAuth(handlers.GetAPIKeys)(response, request)
+		case http.MethodPost:
+			requireAuth(handlers.CreateAPIKey)(response, request)
+		default:
+			http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package authctx
+
+import "context"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID        int
+	Role          string
+	EmailVerified bool
+	// set when the request is authenticated with an API key instead of a session
+	APIKeyID int
+	// what the API key may do, nil for sessions (everything the user may do)
+	Scopes []string
+}
+
+// IsAPIKey reports whether the caller authenticated with an API key
+func (principal Principal) IsAPIKey() bool {
+	return principal.APIKeyID != 0
+}
+
+// HasScope reports whether the caller may act within scope, always true for sessions
+func (principal Principal) HasScope(scope string) bool {
+	if !principal.IsAPIKey() {
+		return true
+	}
+	for _, granted := range principal.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Client describes where a request comes from
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// contextKey is an unexported type for context keys,
+// so values set here cannot collide with keys from other packages
+type contextKey int
+
+const (
+	principalKey contextKey = iota
+	clientKey
+)
+
+// WithPrincipal returns a copy of ctx that carries the authenticated caller
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// PrincipalFromContext returns the authenticated caller stored in ctx.
+// ok is false when the request is anonymous.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(Principal)
+	return principal, ok
+}
+
+// UserID returns the authenticated user ID stored in ctx.
+// ok is false when the request is anonymous.
+func UserID(ctx context.Context) (int, bool) {
+	principal, ok := PrincipalFromContext(ctx)
+	return principal.UserID, ok
+}
+
+// WithClient returns a copy of ctx that carries the client of the request
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey, client)
+}
+
+// ClientFromContext returns the client stored in ctx,
+// an empty Client when the code does not run inside an HTTP request
+func ClientFromContext(ctx context.Context) Client {
+	client, _ := ctx.Value(clientKey).(Client)
+	return client
+}
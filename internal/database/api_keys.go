@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lesson-proj/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// APIKeyRepository stores personal API keys (hash + visible prefix, never the key itself)
+type APIKeyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAPIKeyRepository(db *pgxpool.Pool) *APIKeyRepository {
+	return &APIKeyRepository{
+		db: db,
+	}
+}
+
+func (apiKeyRepository *APIKeyRepository) CreateAPIKey(ctx context.Context, key models.APIKey) (*models.APIKey, error) {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at;`
+	err := apiKeyRepository.db.QueryRow(ctx, query,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		key.Scopes,
+		key.ExpiresAt,
+	).Scan(
+		&key.ID,
+		&key.CreatedAt,
+	)
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("api key with prefix %s %w", key.Prefix, ErrAlreadyExists)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAPIKeysByUserID lists the keys of a user that were not revoked (expired ones included)
+func (apiKeyRepository *APIKeyRepository) GetAPIKeysByUserID(ctx context.Context, userID int) ([]models.APIKey, error) {
+	keys := []models.APIKey{}
+	query := `
+		SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY id;`
+	rows, err := apiKeyRepository.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key models.APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			&key.Scopes,
+			&key.ExpiresAt,
+			&key.LastUsedAt,
+			&key.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// CountActiveAPIKeys counts keys that are neither revoked nor expired
+func (apiKeyRepository *APIKeyRepository) CountActiveAPIKeys(ctx context.Context, userID int) (int, error) {
+	var count int
+	query := `
+		SELECT COUNT(*)
+		FROM api_keys
+		WHERE user_id = $1
+		  AND revoked_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > NOW());`
+	err := apiKeyRepository.db.QueryRow(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// GetAPIKeyOwnerByPrefix loads a key with the current role and state of its user,
+// revoked keys and keys of deactivated or deleted users are not returned
+func (apiKeyRepository *APIKeyRepository) GetAPIKeyOwnerByPrefix(ctx context.Context, prefix string) (*models.APIKeyOwner, error) {
+	var owner models.APIKeyOwner
+	query := `
+		SELECT k.id, k.user_id, k.name, k.prefix, k.key_hash, k.scopes, k.expires_at, k.last_used_at, k.created_at,
+			u.id, u.role, u.email_verified_at IS NOT NULL
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.prefix = $1 AND k.revoked_at IS NULL
+		  AND u.deactivated_at IS NULL AND u.deleted_at IS NULL;`
+	err := apiKeyRepository.db.QueryRow(ctx, query, prefix).Scan(
+		&owner.Key.ID,
+		&owner.Key.UserID,
+		&owner.Key.Name,
+		&owner.Key.Prefix,
+		&owner.Key.KeyHash,
+		&owner.Key.Scopes,
+		&owner.Key.ExpiresAt,
+		&owner.Key.LastUsedAt,
+		&owner.Key.CreatedAt,
+		&owner.User.ID,
+		&owner.User.Role,
+		&owner.User.EmailVerified,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("api key with prefix %s %w", prefix, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &owner, nil
+}
+
+// TouchAPIKey records a use of the key. At most one write per minute per key,
+// so a busy script does not turn every request into an UPDATE.
+func (apiKeyRepository *APIKeyRepository) TouchAPIKey(ctx context.Context, id int) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute');`
+	_, err := apiKeyRepository.db.Exec(ctx, query, id)
+	return err
+}
+
+// RevokeAPIKey revokes a key of the user, ErrNotFound if the user has no such active key
+func (apiKeyRepository *APIKeyRepository) RevokeAPIKey(ctx context.Context, userID int, id int) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;`
+	result, err := apiKeyRepository.db.Exec(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("api key with id %d %w", id, ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"lesson-proj/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AuditEventRepository writes and reads the security log.
+// The table is append-only (a trigger rejects UPDATE and DELETE), so there is no update method.
+type AuditEventRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAuditEventRepository(db *pgxpool.Pool) *AuditEventRepository {
+	return &AuditEventRepository{
+		db: db,
+	}
+}
+
+func (auditEventRepository *AuditEventRepository) InsertEvent(ctx context.Context, event models.AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (event_type, outcome, actor_id, actor_api_key_id, target_type, target_id, ip, user_agent, details)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9);`
+	_, err := auditEventRepository.db.Exec(ctx, query,
+		event.Type,
+		event.Outcome,
+		event.ActorID,
+		event.ActorAPIKeyID,
+		event.TargetType,
+		event.TargetID,
+		event.IP,
+		event.UserAgent,
+		event.Details,
+	)
+	return err
+}
+
+// GetEvents returns matching events, newest first, at most filter.Limit
+func (auditEventRepository *AuditEventRepository) GetEvents(ctx context.Context, filter models.AuditEventFilter) ([]models.AuditEvent, error) {
+	events := []models.AuditEvent{}
+	err := auditEventRepository.ForEachEvent(ctx, filter, func(event models.AuditEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// ForEachEvent streams matching events to fn, newest first, without loading them all in memory.
+// filter.Limit 0 means no limit.
+func (auditEventRepository *AuditEventRepository) ForEachEvent(ctx context.Context, filter models.AuditEventFilter, fn func(event models.AuditEvent) error) error {
+	types := filter.Types
+	if types == nil {
+		types = []string{}
+	}
+	var limit *int
+	if filter.Limit > 0 {
+		limit = &filter.Limit
+	}
+	query := `
+		SELECT id, occurred_at, event_type, outcome, actor_id, actor_api_key_id,
+			COALESCE(target_type, ''), target_id, ip, user_agent, details
+		FROM audit_events
+		WHERE ($1::int IS NULL OR actor_id = $1 OR (target_type = 'user' AND target_id = $1))
+		  AND (cardinality($2::text[]) = 0 OR event_type = ANY($2))
+		  AND ($3 = '' OR outcome = $3)
+		  AND ($4::timestamptz IS NULL OR occurred_at >= $4)
+		  AND ($5::timestamptz IS NULL OR occurred_at < $5)
+		  AND ($6::bigint IS NULL OR id < $6)
+		ORDER BY id DESC
+		LIMIT $7;`
+	rows, err := auditEventRepository.db.Query(ctx, query,
+		filter.UserID,
+		types,
+		filter.Outcome,
+		filter.From,
+		filter.To,
+		filter.BeforeID,
+		limit,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(*event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func scanAuditEvent(rows pgx.Rows) (*models.AuditEvent, error) {
+	var event models.AuditEvent
+	err := rows.Scan(
+		&event.ID,
+		&event.OccurredAt,
+		&event.Type,
+		&event.Outcome,
+		&event.ActorID,
+		&event.ActorAPIKeyID,
+		&event.TargetType,
+		&event.TargetID,
+		&event.IP,
+		&event.UserAgent,
+		&event.Details,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
@@ -2,11 +2,29 @@ package database
 
 import (
 	"context"
+	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// ErrNotFound is wrapped by repository errors when the requested row does not exist,
+// check it with errors.Is
+var ErrNotFound = errors.New("not found")
+
+// ErrAlreadyExists is wrapped when an insert/update violates a unique constraint
+var ErrAlreadyExists = errors.New("already exists")
+
+// uniqueViolation is the PostgreSQL error code for a unique constraint violation
+const uniqueViolation = "23505"
+
+// isUniqueViolation reports whether err comes from a unique constraint
+func isUniqueViolation(err error) bool {
+	var pgError *pgconn.PgError
+	return errors.As(err, &pgError) && pgError.Code == uniqueViolation
+}
+
 // Connect initializes and returns a PostgreSQL connection pool
 func Connect(databaseURL string) (*pgxpool.Pool, error) {
 	ctx := context.Background()
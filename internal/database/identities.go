@@ -0,0 +1,178 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lesson-proj/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// IdentityRepository stores the external OIDC accounts linked to users
+// and the short-lived state of logins in progress
+type IdentityRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewIdentityRepository(db *pgxpool.Pool) *IdentityRepository {
+	return &IdentityRepository{
+		db: db,
+	}
+}
+
+func (identityRepository *IdentityRepository) GetIdentity(ctx context.Context, provider string, subject string) (*models.Identity, error) {
+	var identity models.Identity
+	query := `
+		SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM identities
+		WHERE provider = $1 AND subject = $2;`
+	err := identityRepository.db.QueryRow(ctx, query, provider, subject).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+		&identity.LastLoginAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%s identity %w", provider, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (identityRepository *IdentityRepository) GetIdentitiesByUserID(ctx context.Context, userID int) ([]models.Identity, error) {
+	identities := []models.Identity{}
+	query := `
+		SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM identities
+		WHERE user_id = $1
+		ORDER BY id;`
+	rows, err := identityRepository.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var identity models.Identity
+		err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&identity.CreatedAt,
+			&identity.LastLoginAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// CreateIdentity links an external account. ErrAlreadyExists if the external account
+// is linked already, or the user already has an account at this provider.
+func (identityRepository *IdentityRepository) CreateIdentity(ctx context.Context, identity models.Identity) (*models.Identity, error) {
+	query := `
+		INSERT INTO identities (user_id, provider, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		RETURNING id, created_at, last_login_at;`
+	err := identityRepository.db.QueryRow(ctx, query,
+		identity.UserID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+	).Scan(
+		&identity.ID,
+		&identity.CreatedAt,
+		&identity.LastLoginAt,
+	)
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("%s identity %w", identity.Provider, ErrAlreadyExists)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// TouchIdentity records a login and the email the provider reports now
+func (identityRepository *IdentityRepository) TouchIdentity(ctx context.Context, id int, email string) error {
+	query := `
+		UPDATE identities
+		SET last_login_at = NOW(), email = $1
+		WHERE id = $2;`
+	_, err := identityRepository.db.Exec(ctx, query, email, id)
+	return err
+}
+
+func (identityRepository *IdentityRepository) DeleteIdentity(ctx context.Context, userID int, provider string) error {
+	query := `
+		DELETE FROM identities
+		WHERE user_id = $1 AND provider = $2;`
+	result, err := identityRepository.db.Exec(ctx, query, userID, provider)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%s identity %w", provider, ErrNotFound)
+	}
+	return nil
+}
+
+func (identityRepository *IdentityRepository) CreateLoginState(ctx context.Context, state models.OIDCLoginState) error {
+	query := `
+		INSERT INTO oidc_login_states (state_hash, provider, nonce, code_verifier, device_hash, link_user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7);`
+	_, err := identityRepository.db.Exec(ctx, query,
+		state.StateHash,
+		state.Provider,
+		state.Nonce,
+		state.CodeVerifier,
+		state.DeviceHash,
+		state.LinkUserID,
+		state.ExpiresAt,
+	)
+	return err
+}
+
+// ConsumeLoginState deletes and returns the state, so a callback can be processed only once.
+// Unknown and expired states return ErrNotFound; expired rows are cleaned up on the way.
+func (identityRepository *IdentityRepository) ConsumeLoginState(ctx context.Context, stateHash string) (*models.OIDCLoginState, error) {
+	if _, err := identityRepository.db.Exec(ctx, `DELETE FROM oidc_login_states WHERE expires_at <= NOW();`); err != nil {
+		return nil, err
+	}
+
+	var state models.OIDCLoginState
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state_hash = $1
+		RETURNING state_hash, provider, nonce, code_verifier, device_hash, link_user_id, expires_at;`
+	err := identityRepository.db.QueryRow(ctx, query, stateHash).Scan(
+		&state.StateHash,
+		&state.Provider,
+		&state.Nonce,
+		&state.CodeVerifier,
+		&state.DeviceHash,
+		&state.LinkUserID,
+		&state.ExpiresAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("login state %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lesson-proj/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LoginAttemptRepository keeps failed login counters and lockouts,
+// so they survive a restart of the server
+type LoginAttemptRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewLoginAttemptRepository(db *pgxpool.Pool) *LoginAttemptRepository {
+	return &LoginAttemptRepository{
+		db: db,
+	}
+}
+
+func (loginAttemptRepository *LoginAttemptRepository) GetLoginAttempt(ctx context.Context, scope string, key string) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	query := `
+		SELECT scope, key, failures, last_failure_at, locked_until
+		FROM login_attempts
+		WHERE scope = $1 AND key = $2;`
+	err := loginAttemptRepository.db.QueryRow(ctx, query, scope, key).Scan(
+		&attempt.Scope,
+		&attempt.Key,
+		&attempt.Failures,
+		&attempt.LastFailureAt,
+		&attempt.LockedUntil,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("login attempts for %s %s %w", scope, key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// RecordFailure increments the failure counter and returns it.
+// A counter whose last failure is older than window starts again from 1.
+func (loginAttemptRepository *LoginAttemptRepository) RecordFailure(ctx context.Context, scope string, key string, window time.Duration) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	query := `
+		INSERT INTO login_attempts (scope, key, failures, last_failure_at)
+		VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (scope, key) DO UPDATE
+		SET failures = CASE
+				WHEN login_attempts.last_failure_at < NOW() - ($3::int * INTERVAL '1 second') THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure_at = NOW()
+		RETURNING scope, key, failures, last_failure_at, locked_until;`
+	err := loginAttemptRepository.db.QueryRow(ctx, query, scope, key, int(window.Seconds())).Scan(
+		&attempt.Scope,
+		&attempt.Key,
+		&attempt.Failures,
+		&attempt.LastFailureAt,
+		&attempt.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func (loginAttemptRepository *LoginAttemptRepository) LockUntil(ctx context.Context, scope string, key string, lockedUntil time.Time) error {
+	query := `
+		UPDATE login_attempts
+		SET locked_until = $3
+		WHERE scope = $1 AND key = $2;`
+	_, err := loginAttemptRepository.db.Exec(ctx, query, scope, key, lockedUntil)
+	return err
+}
+
+// ResetLoginAttempts clears the counter and any lockout
+func (loginAttemptRepository *LoginAttemptRepository) ResetLoginAttempts(ctx context.Context, scope string, key string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE scope = $1 AND key = $2;`
+	_, err := loginAttemptRepository.db.Exec(ctx, query, scope, key)
+	return err
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"lesson-proj/internal/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LoginRepository stores the login history, the base of the session list
+type LoginRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewLoginRepository(db *pgxpool.Pool) *LoginRepository {
+	return &LoginRepository{
+		db: db,
+	}
+}
+
+// CreateLogin records a successful login. newDevice reports whether the user signed in
+// before, but never with this device label from this network; the first login of an account is not new.
+func (loginRepository *LoginRepository) CreateLogin(ctx context.Context, login models.Login) (created *models.Login, newDevice bool, err error) {
+	var (
+		previous int
+		onDevice int
+	)
+	// the CTE reads the history as it was before this insert
+	query := `
+		WITH seen AS (
+			SELECT COUNT(*) AS previous,
+				COUNT(*) FILTER (WHERE device_label = $5 AND network = $6) AS on_device
+			FROM logins
+			WHERE user_id = $1
+		)
+		INSERT INTO logins (user_id, family_id, ip, user_agent, device_label, network)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, (SELECT previous FROM seen), (SELECT on_device FROM seen);`
+	err = loginRepository.db.QueryRow(ctx, query,
+		login.UserID,
+		login.FamilyID,
+		login.IP,
+		login.UserAgent,
+		login.DeviceLabel,
+		login.Network,
+	).Scan(
+		&login.ID,
+		&login.CreatedAt,
+		&previous,
+		&onDevice,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	return &login, previous > 0 && onDevice == 0, nil
+}
+
+// GetLoginsByUserID returns the latest logins of a user, newest first, limit 0 returns all
+func (loginRepository *LoginRepository) GetLoginsByUserID(ctx context.Context, userID int, limit int) ([]models.Login, error) {
+	logins := []models.Login{}
+	var limitArg *int
+	if limit > 0 {
+		limitArg = &limit
+	}
+	query := `
+		SELECT id, user_id, ip, user_agent, device_label, created_at
+		FROM logins
+		WHERE user_id = $1
+		ORDER BY id DESC
+		LIMIT $2;`
+	rows, err := loginRepository.db.Query(ctx, query, userID, limitArg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var login models.Login
+		err := rows.Scan(
+			&login.ID,
+			&login.UserID,
+			&login.IP,
+			&login.UserAgent,
+			&login.DeviceLabel,
+			&login.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		logins = append(logins, login)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return logins, nil
+}
+
+// GetActiveSessions lists the logins of a user whose token family still has a usable
+// refresh token: the latest one of the family, neither rotated, revoked nor expired
+func (loginRepository *LoginRepository) GetActiveSessions(ctx context.Context, userID int) ([]models.Session, error) {
+	sessions := []models.Session{}
+	query := `
+		SELECT l.id, l.ip, l.user_agent, l.device_label, l.created_at, t.created_at, t.expires_at
+		FROM logins l
+		JOIN refresh_tokens t ON t.family_id = l.family_id
+		WHERE l.user_id = $1
+		  AND t.rotated_at IS NULL
+		  AND t.revoked_at IS NULL
+		  AND t.expires_at > NOW()
+		ORDER BY t.created_at DESC;`
+	rows, err := loginRepository.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(
+			&session.ID,
+			&session.IP,
+			&session.UserAgent,
+			&session.DeviceLabel,
+			&session.SignedInAt,
+			&session.LastActiveAt,
+			&session.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lesson-proj/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// OneTimeTokenRepository stores single-use, expiring tokens sent by email
+// (email verification, password reset, ...). The purpose column keeps
+// a token of one kind from being redeemed as another.
+type OneTimeTokenRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewOneTimeTokenRepository(db *pgxpool.Pool) *OneTimeTokenRepository {
+	return &OneTimeTokenRepository{
+		db: db,
+	}
+}
+
+func (oneTimeTokenRepository *OneTimeTokenRepository) CreateToken(ctx context.Context, token models.OneTimeToken) error {
+	query := `
+		INSERT INTO one_time_tokens (user_id, purpose, token_hash, payload, expires_at)
+		VALUES ($1, $2, $3, $4, $5);`
+	_, err := oneTimeTokenRepository.db.Exec(ctx, query,
+		token.UserID,
+		token.Purpose,
+		token.TokenHash,
+		token.Payload,
+		token.ExpiresAt,
+	)
+	return err
+}
+
+// GetValidToken returns an unused, unexpired token without consuming it,
+// for flows that allow several attempts before the token is redeemed (2FA challenge)
+func (oneTimeTokenRepository *OneTimeTokenRepository) GetValidToken(ctx context.Context, purpose string, tokenHash string) (*models.OneTimeToken, error) {
+	var token models.OneTimeToken
+	query := `
+		SELECT id, user_id, purpose, token_hash, payload, expires_at
+		FROM one_time_tokens
+		WHERE token_hash = $1
+		  AND purpose = $2
+		  AND used_at IS NULL
+		  AND expires_at > NOW();`
+	err := oneTimeTokenRepository.db.QueryRow(ctx, query, tokenHash, purpose).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Purpose,
+		&token.TokenHash,
+		&token.Payload,
+		&token.ExpiresAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%s token %w", purpose, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ConsumeToken marks the token as used and returns it.
+// The check and the update are one statement, so a token can be redeemed only once
+// even by parallel requests. Unknown, used and expired tokens all return ErrNotFound.
+func (oneTimeTokenRepository *OneTimeTokenRepository) ConsumeToken(ctx context.Context, purpose string, tokenHash string) (*models.OneTimeToken, error) {
+	return consumeToken(ctx, oneTimeTokenRepository.db, purpose, tokenHash)
+}
+
+// rowQuerier is a pool or a transaction
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// consumeToken is ConsumeToken on a pool or inside a transaction of another repository
+func consumeToken(ctx context.Context, db rowQuerier, purpose string, tokenHash string) (*models.OneTimeToken, error) {
+	var token models.OneTimeToken
+	query := `
+		UPDATE one_time_tokens
+		SET used_at = NOW()
+		WHERE token_hash = $1
+		  AND purpose = $2
+		  AND used_at IS NULL
+		  AND expires_at > NOW()
+		RETURNING id, user_id, purpose, token_hash, payload, expires_at;`
+	err := db.QueryRow(ctx, query, tokenHash, purpose).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Purpose,
+		&token.TokenHash,
+		&token.Payload,
+		&token.ExpiresAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%s token %w", purpose, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// CountTokensSince counts the tokens of a purpose issued to the user since the given time,
+// used or not, for rate limits on emailed links
+func (oneTimeTokenRepository *OneTimeTokenRepository) CountTokensSince(ctx context.Context, userID int, purpose string, since time.Time) (int, error) {
+	var count int
+	query := `
+		SELECT COUNT(*)
+		FROM one_time_tokens
+		WHERE user_id = $1 AND purpose = $2 AND created_at >= $3;`
+	err := oneTimeTokenRepository.db.QueryRow(ctx, query, userID, purpose, since).Scan(&count)
+	return count, err
+}
+
+// InvalidateUserTokens marks all unused tokens of the given purpose as used,
+// e.g. an older verification link stops working once a new one is sent
+func (oneTimeTokenRepository *OneTimeTokenRepository) InvalidateUserTokens(ctx context.Context, userID int, purpose string) error {
+	query := `
+		UPDATE one_time_tokens
+		SET used_at = NOW()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL;`
+	_, err := oneTimeTokenRepository.db.Exec(ctx, query, userID, purpose)
+	return err
+}
@@ -39,21 +39,28 @@ func NewProductRepository(db *pgxpool.Pool) *ProductRepository {
 	}
 }
 
-func (productRepository *ProductRepository) GetAllProducts(ctx context.Context) ([]models.Product, error) {
+func (productRepository *ProductRepository) GetAllProducts(ctx context.Context, filter models.ProductFilter) ([]models.Product, error) {
 	// Declare a slice to store products fetched from the database.
 	// At this point it is nil and has length 0.
 	var products []models.Product
 
 	// SQL query to select all products.
 	// Backticks are used to allow a multi-line string.
+	// ($1::int IS NULL OR ...) — the filter is skipped when seller_id is not provided
+	// deleted products, and products of deleted or deactivated sellers,
+	// are only returned when the filter asks for them; erased sellers stay deleted,
+	// but their listings remain online as "Deleted user"
 	query := `
-		SELECT id, title, description, price, created_at 
-		FROM products 
-		ORDER BY created_at;`
+		SELECT p.id, p.title, p.description, p.price, p.seller_id, p.created_at, p.deleted_at
+		FROM products p
+		JOIN users u ON u.id = p.seller_id
+		WHERE ($1::int IS NULL OR p.seller_id = $1)
+		  AND ($2 OR (p.deleted_at IS NULL AND (u.deleted_at IS NULL OR u.erased_at IS NOT NULL) AND u.deactivated_at IS NULL))
+		ORDER BY p.created_at;`
 
 	// rows is products from db
 	// Query - for multiple rows
-	rows, err := productRepository.db.Query(ctx, query)
+	rows, err := productRepository.db.Query(ctx, query, filter.SellerID, filter.IncludeDeleted)
 
 	if err != nil {
 		return nil, err
@@ -69,7 +76,9 @@ func (productRepository *ProductRepository) GetAllProducts(ctx context.Context)
 			&product.Title,
 			&product.Description,
 			&product.Price,
+			&product.SellerID,
 			&product.CreatedAt,
+			&product.DeletedAt,
 		)
 
 		if err != nil {
@@ -96,10 +105,16 @@ func (productRepository *ProductRepository) GetProductByID(ctx context.Context,
 	// SQL query to fetch a single product by its ID.
 	// $1 is a positional placeholder for the id parameter (PostgreSQL syntax).
 	// Using placeholders prevents SQL injection.
+	// Deleted products and products of deleted or deactivated sellers are not found,
+	// listings of erased sellers are.
 	query := `
-		SELECT id, title, description, price, created_at
-		FROM products
-		WHERE id = $1;
+		SELECT p.id, p.title, p.description, p.price, p.seller_id, p.created_at
+		FROM products p
+		JOIN users u ON u.id = p.seller_id
+		WHERE p.id = $1
+		  AND p.deleted_at IS NULL
+		  AND (u.deleted_at IS NULL OR u.erased_at IS NOT NULL)
+		  AND u.deactivated_at IS NULL;
 	`
 
 	// QueryRow is used for a single row result.
@@ -110,12 +125,13 @@ func (productRepository *ProductRepository) GetProductByID(ctx context.Context,
 		&product.Title,
 		&product.Description,
 		&product.Price,
+		&product.SellerID,
 		&product.CreatedAt,
 	)
 	// Check if the error indicates that no rows were found.
 	// erros.Is checks if the error is of type pgx.ErrNoRows
 	if errors.Is(err, pgx.ErrNoRows) {
-		return nil, fmt.Errorf("product with id %d not found", id)
+		return nil, fmt.Errorf("product with id %d %w", id, ErrNotFound)
 	}
 
 	if err != nil {
@@ -131,20 +147,22 @@ func (productRepository *ProductRepository) CreateProduct(ctx context.Context, i
 	var product models.Product
 
 	query := `
-		INSERT INTO products (title, description, price, created_at)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, title, description, price, created_at;`
+		INSERT INTO products (title, description, price, seller_id, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, title, description, price, seller_id, created_at;`
 	timeNow := time.Now()
 	err := productRepository.db.QueryRow(ctx, query,
 		inputProduct.Title,
 		inputProduct.Description,
 		inputProduct.Price,
+		inputProduct.SellerID,
 		timeNow,
 	).Scan(
 		&product.ID,
 		&product.Title,
 		&product.Description,
 		&product.Price,
+		&product.SellerID,
 		&product.CreatedAt,
 	)
 	if err != nil {
@@ -160,8 +178,8 @@ func (productRepository *ProductRepository) UpdateProduct(ctx context.Context, i
 			title = COALESCE($1, title),
 			description  = COALESCE($2, description),
 			price = COALESCE($3, price)
-		WHERE id = $4
-		RETURNING id, title, description, price, created_at;
+		WHERE id = $4 AND deleted_at IS NULL
+		RETURNING id, title, description, price, seller_id, created_at;
 	`
 	var updatedProduct models.Product
 	err := productRepository.db.QueryRow(ctx, query,
@@ -174,18 +192,25 @@ func (productRepository *ProductRepository) UpdateProduct(ctx context.Context, i
 		&updatedProduct.Title,
 		&updatedProduct.Description,
 		&updatedProduct.Price,
+		&updatedProduct.SellerID,
 		&updatedProduct.CreatedAt,
 	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("product with id %d %w", id, ErrNotFound)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &updatedProduct, nil
 }
 
+// DeleteProduct soft deletes the product: it is hidden from the queries above
+// until RestoreProduct, PurgeDeletedProducts removes it for good
 func (productRepository *ProductRepository) DeleteProduct(ctx context.Context, id int) error {
 	query := `
-		DELETE FROM products
-		WHERE id = $1;`
+		UPDATE products
+		SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL;`
 	// Exec is used for queries that do not return rows
 	result, err := productRepository.db.Exec(ctx, query, id)
 	if err != nil {
@@ -195,7 +220,45 @@ func (productRepository *ProductRepository) DeleteProduct(ctx context.Context, i
 	rows := result.RowsAffected()
 	// if product with given id not found
 	if rows == 0 {
-		return fmt.Errorf("product with id %d not found", id)
+		return fmt.Errorf("product with id %d %w", id, ErrNotFound)
 	}
 	return nil
 }
+
+// RestoreProduct undoes DeleteProduct, ErrNotFound when the product is not deleted (or purged)
+func (productRepository *ProductRepository) RestoreProduct(ctx context.Context, id int) (*models.Product, error) {
+	query := `
+		UPDATE products
+		SET deleted_at = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING id, title, description, price, seller_id, created_at;`
+	var product models.Product
+	err := productRepository.db.QueryRow(ctx, query, id).Scan(
+		&product.ID,
+		&product.Title,
+		&product.Description,
+		&product.Price,
+		&product.SellerID,
+		&product.CreatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("deleted product with id %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// PurgeDeletedProducts removes the products deleted before the given time,
+// returns how many were removed
+func (productRepository *ProductRepository) PurgeDeletedProducts(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	query := `
+		DELETE FROM products
+		WHERE deleted_at < $1;`
+	result, err := productRepository.db.Exec(ctx, query, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
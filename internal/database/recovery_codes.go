@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RecoveryCodeRepository stores the SHA-256 hashes of 2FA recovery codes,
+// each code can be used once instead of a TOTP code
+type RecoveryCodeRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewRecoveryCodeRepository(db *pgxpool.Pool) *RecoveryCodeRepository {
+	return &RecoveryCodeRepository{
+		db: db,
+	}
+}
+
+// ReplaceCodes deletes the old codes of the user and stores the new ones in one transaction
+func (recoveryCodeRepository *RecoveryCodeRepository) ReplaceCodes(ctx context.Context, userID int, codeHashes []string) error {
+	tx, err := recoveryCodeRepository.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op after a successful Commit
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1;`, userID); err != nil {
+		return err
+	}
+	for _, codeHash := range codeHashes {
+		query := `
+			INSERT INTO recovery_codes (user_id, code_hash)
+			VALUES ($1, $2);`
+		if _, err := tx.Exec(ctx, query, userID, codeHash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// UseCode marks a code as used, false if the user has no such unused code
+func (recoveryCodeRepository *RecoveryCodeRepository) UseCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;`
+	result, err := recoveryCodeRepository.db.Exec(ctx, query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+func (recoveryCodeRepository *RecoveryCodeRepository) DeleteForUser(ctx context.Context, userID int) error {
+	_, err := recoveryCodeRepository.db.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1;`, userID)
+	return err
+}
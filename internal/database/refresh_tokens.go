@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"lesson-proj/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenExpired  = errors.New("refresh token expired")
+	// ErrRefreshTokenReused means an already rotated or revoked token was presented again,
+	// the whole token family is revoked when this happens
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// RefreshTokenRepository stores refresh tokens.
+// Only a SHA-256 hash of each token is kept, never the token itself.
+type RefreshTokenRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewRefreshTokenRepository(db *pgxpool.Pool) *RefreshTokenRepository {
+	return &RefreshTokenRepository{
+		db: db,
+	}
+}
+
+func (refreshTokenRepository *RefreshTokenRepository) CreateRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4);`
+	_, err := refreshTokenRepository.db.Exec(ctx, query,
+		token.UserID,
+		token.FamilyID,
+		token.TokenHash,
+		token.ExpiresAt,
+	)
+	return err
+}
+
+// RotateRefreshToken marks the token with oldHash as used and stores its replacement
+// in the same family. Everything happens in one transaction with the old row locked,
+// so two parallel requests with the same token cannot both succeed.
+func (refreshTokenRepository *RefreshTokenRepository) RotateRefreshToken(ctx context.Context, oldHash string, newHash string, newExpiresAt time.Time) (*models.RefreshToken, error) {
+	tx, err := refreshTokenRepository.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op after a successful Commit
+	defer tx.Rollback(ctx)
+
+	var (
+		current   models.RefreshToken
+		rotatedAt *time.Time
+		revokedAt *time.Time
+	)
+	query := `
+		SELECT id, user_id, family_id, token_hash, expires_at, rotated_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE;`
+	err = tx.QueryRow(ctx, query, oldHash).Scan(
+		&current.ID,
+		&current.UserID,
+		&current.FamilyID,
+		&current.TokenHash,
+		&current.ExpiresAt,
+		&rotatedAt,
+		&revokedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// token was already used (or its family revoked): someone replays a stolen token,
+	// cut off every token of this family so the attacker and the victim both have to log in again
+	if rotatedAt != nil || revokedAt != nil {
+		if err := revokeFamily(ctx, tx, current.FamilyID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(current.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE refresh_tokens
+		SET rotated_at = NOW()
+		WHERE id = $1;`, current.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	next := models.RefreshToken{
+		UserID:    current.UserID,
+		FamilyID:  current.FamilyID,
+		TokenHash: newHash,
+		ExpiresAt: newExpiresAt,
+	}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id;`,
+		next.UserID,
+		next.FamilyID,
+		next.TokenHash,
+		next.ExpiresAt,
+	).Scan(&next.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &next, nil
+}
+
+// RevokeFamilyByTokenHash revokes the family the given token belongs to (logout of one device)
+func (refreshTokenRepository *RefreshTokenRepository) RevokeFamilyByTokenHash(ctx context.Context, tokenHash string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = NOW()
+		WHERE revoked_at IS NULL
+		  AND family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1);`
+	_, err := refreshTokenRepository.db.Exec(ctx, query, tokenHash)
+	return err
+}
+
+// RevokeAllForUser revokes every refresh token of the user (logout of all devices)
+func (refreshTokenRepository *RefreshTokenRepository) RevokeAllForUser(ctx context.Context, userID int) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL;`
+	_, err := refreshTokenRepository.db.Exec(ctx, query, userID)
+	return err
+}
+
+func revokeFamily(ctx context.Context, tx pgx.Tx, familyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL;`
+	_, err := tx.Exec(ctx, query, familyID)
+	return err
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"lesson-proj/internal/models"
+	"time"
 )
 
 type UserRepository struct {
@@ -19,21 +20,27 @@ func NewUserRepository(db *pgxpool.Pool) *UserRepository {
 	}
 }
 
+// GetUserByEmail matches case-insensitively (served by the unique index on LOWER(email)),
+// callers pass the address normalized by authUtils.NormalizeEmail.
+// Like every get and list below, it skips deactivated and deleted accounts.
 func (userRepository *UserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
 	query := `
-		SELECT id, email, name, hashed_password
+		SELECT id, email, name, hashed_password, role, email_verified_at IS NOT NULL, totp_enabled_at IS NOT NULL
 		FROM users
-		WHERE email = $1;
+		WHERE LOWER(email) = LOWER($1) AND deactivated_at IS NULL AND deleted_at IS NULL;
 	`
 	err := userRepository.db.QueryRow(ctx, query, email).Scan(
 		&user.ID,
 		&user.Email,
 		&user.Name,
 		&user.HashedPassword,
+		&user.Role,
+		&user.EmailVerified,
+		&user.TwoFactorEnabled,
 	)
 	if errors.Is(err, pgx.ErrNoRows) {
-		return nil, fmt.Errorf("user with email %s not found", email)
+		return nil, fmt.Errorf("user with email %s %w", email, ErrNotFound)
 	}
 	if err != nil {
 		return nil, err
@@ -42,11 +49,22 @@ func (userRepository *UserRepository) GetUserByEmail(ctx context.Context, email
 	return &user, nil
 }
 
-func (userRepository *UserRepository) GetAllUsers(ctx context.Context) ([]models.UserWithoutPassword, error) {
+// EmailTaken reports whether any account holds the address, deactivated and deleted
+// ones included: their rows keep the address until the erasure, so it cannot be taken
+func (userRepository *UserRepository) EmailTaken(ctx context.Context, email string) (bool, error) {
+	var taken bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER($1));`
+	err := userRepository.db.QueryRow(ctx, query, email).Scan(&taken)
+	return taken, err
+}
+
+func (userRepository *UserRepository) GetAllUsers(ctx context.Context, filter models.UserFilter) ([]models.UserWithoutPassword, error) {
 	var users []models.UserWithoutPassword
 	query := `
-		SELECT id, email, name FROM users;`
-	rows, err := userRepository.db.Query(ctx, query)
+		SELECT id, email, name, role, email_verified_at IS NOT NULL, deactivated_at, deleted_at
+		FROM users
+		WHERE $1 OR (deactivated_at IS NULL AND deleted_at IS NULL);`
+	rows, err := userRepository.db.Query(ctx, query, filter.IncludeInactive)
 
 	if err != nil {
 		return nil, err
@@ -59,6 +77,10 @@ func (userRepository *UserRepository) GetAllUsers(ctx context.Context) ([]models
 			&user.ID,
 			&user.Email,
 			&user.Name,
+			&user.Role,
+			&user.EmailVerified,
+			&user.DeactivatedAt,
+			&user.DeletedAt,
 		)
 
 		if err != nil {
@@ -76,17 +98,19 @@ func (userRepository *UserRepository) GetAllUsers(ctx context.Context) ([]models
 func (userRepository *UserRepository) GetUserByID(ctx context.Context, id int) (*models.UserWithoutPassword, error) {
 	var user models.UserWithoutPassword
 	query := `
-		SELECT id, email, name
+		SELECT id, email, name, role, email_verified_at IS NOT NULL
 		FROM users
-		WHERE id = $1;
+		WHERE id = $1 AND deactivated_at IS NULL AND deleted_at IS NULL;
 	`
 	err := userRepository.db.QueryRow(ctx, query, id).Scan(
 		&user.ID,
 		&user.Email,
 		&user.Name,
+		&user.Role,
+		&user.EmailVerified,
 	)
 	if errors.Is(err, pgx.ErrNoRows) {
-		return nil, fmt.Errorf("user with id %d not found", id)
+		return nil, fmt.Errorf("user with id %d %w", id, ErrNotFound)
 	}
 	if err != nil {
 		return nil, err
@@ -99,37 +123,66 @@ func (userRepository *UserRepository) CreateUser(ctx context.Context, inputUser
 	var user models.UserWithoutPassword
 
 	query := `
-		INSERT INTO users (email, name, hashed_password)
-		VALUES ($1, $2, $3)
-		RETURNING id, email, name;`
+		INSERT INTO users (email, name, hashed_password, role)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, email, name, role, email_verified_at IS NOT NULL;`
 	err := userRepository.db.QueryRow(ctx, query,
 		inputUser.Email,
 		inputUser.Name,
 		inputUser.Password,
+		inputUser.Role,
 	).Scan(
 		&user.ID,
 		&user.Email,
 		&user.Name,
+		&user.Role,
+		&user.EmailVerified,
 	)
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("user with email %s %w", inputUser.Email, ErrAlreadyExists)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (userRepository *UserRepository) UpdateUser(ctx context.Context, id int, inputUser models.UpdateUser) (*models.UserWithoutPassword, error) {
+// UpdateUser changes the given fields and returns the user with the email it had before.
+// A new email is not verified yet: verification is cleared and every pending single-use token
+// (sign-in links, password resets, email changes) stops working, since it was sent to the old address.
+func (userRepository *UserRepository) UpdateUser(ctx context.Context, id int, inputUser models.UpdateUser) (*models.UserWithoutPassword, string, error) {
+	tx, err := userRepository.db.Begin(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	// Rollback is a no-op after a successful Commit
+	defer tx.Rollback(ctx)
+
+	var previousEmail string
+	err = tx.QueryRow(ctx, `SELECT email FROM users WHERE id = $1 FOR UPDATE;`, id).Scan(&previousEmail)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, "", fmt.Errorf("user with id %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, "", err
+	}
 
+	// Password must already be hashed. It is written in the same statement as the other
+	// fields, so a rejected email leaves the password unchanged; like UpdatePassword,
+	// a new password revokes the access tokens issued before it.
 	query := `
 		UPDATE users
 		SET
 			email = COALESCE($1, email),
 			name  = COALESCE($2, name),
-			hashed_password = COALESCE($3, hashed_password)
+			hashed_password = COALESCE($3::varchar, hashed_password),
+			sessions_revoked_at = CASE WHEN $3::varchar IS NULL THEN sessions_revoked_at ELSE NOW() END,
+			email_verified_at = CASE WHEN $1::varchar IS NULL OR $1 = email THEN email_verified_at END
 		WHERE id = $4
-		RETURNING id, email, name;
+		RETURNING id, email, name, role, email_verified_at IS NOT NULL;
 	`
 	var updatedUser models.UserWithoutPassword
-	err := userRepository.db.QueryRow(
+	err = tx.QueryRow(
 		ctx,
 		query,
 		inputUser.Email,
@@ -140,25 +193,794 @@ func (userRepository *UserRepository) UpdateUser(ctx context.Context, id int, in
 		&updatedUser.ID,
 		&updatedUser.Email,
 		&updatedUser.Name,
+		&updatedUser.Role,
+		&updatedUser.EmailVerified,
+	)
+	if isUniqueViolation(err) {
+		return nil, "", fmt.Errorf("user with this email %w", ErrAlreadyExists)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if updatedUser.Email != previousEmail {
+		query := `
+			UPDATE one_time_tokens
+			SET used_at = NOW()
+			WHERE user_id = $1 AND used_at IS NULL;`
+		if _, err := tx.Exec(ctx, query, id); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, "", err
+	}
+	return &updatedUser, previousEmail, nil
+}
+
+// SetNormalizedEmail stores the normalized form of the same address (lowercase, punycode
+// domain); unlike an email change through UpdateUser it keeps the verification
+func (userRepository *UserRepository) SetNormalizedEmail(ctx context.Context, id int, email string) error {
+	result, err := userRepository.db.Exec(ctx, `UPDATE users SET email = $1 WHERE id = $2;`, email, id)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("user with this email %w", ErrAlreadyExists)
+	}
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user with id %d %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func (userRepository *UserRepository) UpdateUserRole(ctx context.Context, id int, role string) (*models.UserWithoutPassword, error) {
+	query := `
+		UPDATE users
+		SET role = $1
+		WHERE id = $2
+		RETURNING id, email, name, role, email_verified_at IS NOT NULL;
+	`
+	var updatedUser models.UserWithoutPassword
+	err := userRepository.db.QueryRow(ctx, query, role, id).Scan(
+		&updatedUser.ID,
+		&updatedUser.Email,
+		&updatedUser.Name,
+		&updatedUser.Role,
+		&updatedUser.EmailVerified,
 	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("user with id %d %w", id, ErrNotFound)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &updatedUser, nil
 }
 
-func (userRepository *UserRepository) DeleteUser(ctx context.Context, id int) error {
+func (userRepository *UserRepository) GetUserWithPasswordByID(ctx context.Context, id int) (*models.User, error) {
+	var user models.User
+	query := `
+		SELECT id, email, name, hashed_password, role, email_verified_at IS NOT NULL, totp_enabled_at IS NOT NULL
+		FROM users
+		WHERE id = $1 AND deactivated_at IS NULL AND deleted_at IS NULL;
+	`
+	err := userRepository.db.QueryRow(ctx, query, id).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Name,
+		&user.HashedPassword,
+		&user.Role,
+		&user.EmailVerified,
+		&user.TwoFactorEnabled,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("user with id %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (userRepository *UserRepository) GetUserAuthState(ctx context.Context, id int) (*models.UserAuthState, error) {
+	var state models.UserAuthState
+	query := `
+		SELECT id, role, email_verified_at IS NOT NULL, sessions_revoked_at
+		FROM users
+		WHERE id = $1 AND deactivated_at IS NULL AND deleted_at IS NULL;
+	`
+	err := userRepository.db.QueryRow(ctx, query, id).Scan(
+		&state.ID,
+		&state.Role,
+		&state.EmailVerified,
+		&state.SessionsRevokedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("user with id %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// UpdatePassword stores a new password hash (never plaintext!)
+// and invalidates every access token issued before the change
+func (userRepository *UserRepository) UpdatePassword(ctx context.Context, id int, hashedPassword string) error {
+	query := `
+		UPDATE users
+		SET hashed_password = $1,
+			sessions_revoked_at = NOW()
+		WHERE id = $2;`
+	result, err := userRepository.db.Exec(ctx, query, hashedPassword, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user with id %d %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// ReplacePasswordHash swaps the stored hash only if it still equals oldHash
+// (compare-and-swap), so a rehash after login can never overwrite a password
+// that was changed in the meantime. Sessions are kept: the password is the same.
+func (userRepository *UserRepository) ReplacePasswordHash(ctx context.Context, id int, oldHash string, newHash string) (bool, error) {
+	query := `
+		UPDATE users
+		SET hashed_password = $1
+		WHERE id = $2 AND hashed_password = $3;`
+	result, err := userRepository.db.Exec(ctx, query, newHash, id, oldHash)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// ForEachPasswordHash streams the stored hash of every user to fn,
+// used by maintenance commands (e.g. counting users per pepper)
+func (userRepository *UserRepository) ForEachPasswordHash(ctx context.Context, fn func(id int, hashedPassword string) error) error {
+	rows, err := userRepository.db.Query(ctx, `SELECT id, hashed_password FROM users ORDER BY id;`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id             int
+			hashedPassword string
+		)
+		if err := rows.Scan(&id, &hashedPassword); err != nil {
+			return err
+		}
+		if err := fn(id, hashedPassword); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ForEachUserEmail streams every user to fn, used by the email normalization command
+func (userRepository *UserRepository) ForEachUserEmail(ctx context.Context, fn func(user models.UserWithoutPassword) error) error {
+	query := `
+		SELECT id, email, name, role, email_verified_at IS NOT NULL
+		FROM users
+		ORDER BY id;
+	`
+	rows, err := userRepository.db.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user models.UserWithoutPassword
+		if err := rows.Scan(&user.ID, &user.Email, &user.Name, &user.Role, &user.EmailVerified); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// EnsureCaseInsensitiveEmails replaces the case-sensitive UNIQUE constraint of databases
+// created before emails were normalized with the unique index on LOWER(email).
+// It fails while two users still share an email in different casing.
+func (userRepository *UserRepository) EnsureCaseInsensitiveEmails(ctx context.Context) error {
+	tx, err := userRepository.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (LOWER(email));`); err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("users share an email in different casing: %w", ErrAlreadyExists)
+		}
+		return err
+	}
+	if _, err := tx.Exec(ctx, `ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;`); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ConfirmEmailChange redeems an email change confirmation and sets its new address
+// (the token payload) as the verified email of its user, in one transaction: the address
+// changes only together with the redemption, and a taken address (ErrAlreadyExists)
+// leaves both untouched. Other pending confirmations of the user stop working.
+func (userRepository *UserRepository) ConfirmEmailChange(ctx context.Context, tokenHash string) (*models.OneTimeToken, error) {
+	tx, err := userRepository.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	token, err := consumeToken(ctx, tx, models.TokenPurposeEmailChange, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE users
+		SET email = $2, email_verified_at = NOW()
+		WHERE id = $1;`, token.UserID, token.Payload); err != nil {
+		if isUniqueViolation(err) {
+			return nil, fmt.Errorf("user with email %s %w", token.Payload, ErrAlreadyExists)
+		}
+		return nil, err
+	}
+	if err := invalidateEmailChanges(ctx, tx, token.UserID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// CancelEmailChange redeems a cancel link sent to the old address: pending confirmations
+// stop working and, if the change was already confirmed, the old address (the token payload)
+// is restored. reverted reports whether the email was changed back.
+func (userRepository *UserRepository) CancelEmailChange(ctx context.Context, tokenHash string) (token *models.OneTimeToken, reverted bool, err error) {
+	tx, err := userRepository.db.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	token, err = consumeToken(ctx, tx, models.TokenPurposeEmailChangeCancel, tokenHash)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := invalidateEmailChanges(ctx, tx, token.UserID); err != nil {
+		return nil, false, err
+	}
+	result, err := tx.Exec(ctx, `
+		UPDATE users
+		SET email = $2, email_verified_at = NOW()
+		WHERE id = $1 AND email <> $2;`, token.UserID, token.Payload)
+	if isUniqueViolation(err) {
+		return nil, false, fmt.Errorf("user with email %s %w", token.Payload, ErrAlreadyExists)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+	return token, result.RowsAffected() == 1, nil
+}
+
+func invalidateEmailChanges(ctx context.Context, tx pgx.Tx, userID int) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE one_time_tokens
+		SET used_at = NOW()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL;`, userID, models.TokenPurposeEmailChange)
+	return err
+}
+
+// GetProfile returns the own profile of an active user
+func (userRepository *UserRepository) GetProfile(ctx context.Context, id int) (*models.Profile, error) {
+	query := `
+		SELECT id, email, email_verified_at IS NOT NULL, name, role,
+			COALESCE(display_name, ''), COALESCE(handle, ''), COALESCE(bio, ''), COALESCE(avatar_url, ''),
+			COALESCE(status_text, ''), COALESCE(status_emoji, ''), last_seen_at
+		FROM users
+		WHERE id = $1 AND deactivated_at IS NULL AND deleted_at IS NULL;`
+	profile, err := scanProfile(userRepository.db.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("user with id %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// UpdateProfile sets the profile fields that are not nil, an empty string stores NULL.
+// A handle used by another account (case-insensitively) is ErrAlreadyExists.
+func (userRepository *UserRepository) UpdateProfile(ctx context.Context, id int, input models.UpdateProfile) (*models.Profile, error) {
+	// NULLIF(COALESCE(...), '') — nil keeps the field, "" clears it
+	query := `
+		UPDATE users
+		SET
+			display_name = NULLIF(COALESCE($1, display_name), ''),
+			handle = NULLIF(COALESCE($2, handle), ''),
+			bio = NULLIF(COALESCE($3, bio), ''),
+			avatar_url = NULLIF(COALESCE($4, avatar_url), ''),
+			status_text = NULLIF(COALESCE($5, status_text), ''),
+			status_emoji = NULLIF(COALESCE($6, status_emoji), '')
+		WHERE id = $7 AND deactivated_at IS NULL AND deleted_at IS NULL
+		RETURNING id, email, email_verified_at IS NOT NULL, name, role,
+			COALESCE(display_name, ''), COALESCE(handle, ''), COALESCE(bio, ''), COALESCE(avatar_url, ''),
+			COALESCE(status_text, ''), COALESCE(status_emoji, ''), last_seen_at;`
+	profile, err := scanProfile(userRepository.db.QueryRow(ctx, query,
+		input.DisplayName,
+		input.Handle,
+		input.Bio,
+		input.AvatarURL,
+		input.StatusText,
+		input.StatusEmoji,
+		id,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("user with id %d %w", id, ErrNotFound)
+	}
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("user with this handle %w", ErrAlreadyExists)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+func scanProfile(row pgx.Row) (*models.Profile, error) {
+	var profile models.Profile
+	err := row.Scan(
+		&profile.ID,
+		&profile.Email,
+		&profile.EmailVerified,
+		&profile.Name,
+		&profile.Role,
+		&profile.DisplayName,
+		&profile.Handle,
+		&profile.Bio,
+		&profile.AvatarURL,
+		&profile.StatusText,
+		&profile.StatusEmoji,
+		&profile.LastSeenAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// GetPublicProfile returns the profile other users see, without the email
+func (userRepository *UserRepository) GetPublicProfile(ctx context.Context, id int) (*models.PublicProfile, error) {
+	query := `
+		SELECT id, COALESCE(handle, ''), COALESCE(display_name, name), COALESCE(bio, ''), COALESCE(avatar_url, ''),
+			COALESCE(status_text, ''), COALESCE(status_emoji, ''), last_seen_at
+		FROM users
+		WHERE id = $1 AND deactivated_at IS NULL AND deleted_at IS NULL;`
+	profile, err := scanPublicProfile(userRepository.db.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("user with id %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// GetPublicProfileByHandle finds a profile by @handle, case-insensitively
+// (served by the unique index on LOWER(handle))
+func (userRepository *UserRepository) GetPublicProfileByHandle(ctx context.Context, handle string) (*models.PublicProfile, error) {
 	query := `
-		DELETE FROM users
+		SELECT id, COALESCE(handle, ''), COALESCE(display_name, name), COALESCE(bio, ''), COALESCE(avatar_url, ''),
+			COALESCE(status_text, ''), COALESCE(status_emoji, ''), last_seen_at
+		FROM users
+		WHERE LOWER(handle) = LOWER($1) AND deactivated_at IS NULL AND deleted_at IS NULL;`
+	profile, err := scanPublicProfile(userRepository.db.QueryRow(ctx, query, handle))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("user with handle %s %w", handle, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+func scanPublicProfile(row pgx.Row) (*models.PublicProfile, error) {
+	var profile models.PublicProfile
+	err := row.Scan(
+		&profile.ID,
+		&profile.Handle,
+		&profile.DisplayName,
+		&profile.Bio,
+		&profile.AvatarURL,
+		&profile.StatusText,
+		&profile.StatusEmoji,
+		&profile.LastSeenAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// TouchLastSeen records that the user is online. At most one write per minute per user,
+// so every signed-in request does not turn into an UPDATE.
+func (userRepository *UserRepository) TouchLastSeen(ctx context.Context, id int) error {
+	query := `
+		UPDATE users
+		SET last_seen_at = NOW()
+		WHERE id = $1 AND (last_seen_at IS NULL OR last_seen_at < NOW() - INTERVAL '1 minute');`
+	_, err := userRepository.db.Exec(ctx, query, id)
+	return err
+}
+
+// RevokeSessions invalidates every access token of the user issued before now
+func (userRepository *UserRepository) RevokeSessions(ctx context.Context, id int) error {
+	query := `
+		UPDATE users
+		SET sessions_revoked_at = NOW()
+		WHERE id = $1;`
+	_, err := userRepository.db.Exec(ctx, query, id)
+	return err
+}
+
+// GetTwoFactorState returns the stored (encrypted) TOTP secret and whether it is confirmed
+func (userRepository *UserRepository) GetTwoFactorState(ctx context.Context, id int) (*models.TwoFactorState, error) {
+	var state models.TwoFactorState
+	query := `
+		SELECT totp_secret, totp_enabled_at IS NOT NULL, totp_last_step
+		FROM users
+		WHERE id = $1;
+	`
+	err := userRepository.db.QueryRow(ctx, query, id).Scan(
+		&state.EncryptedSecret,
+		&state.Enabled,
+		&state.LastUsedStep,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("user with id %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// SetPendingTOTPSecret stores a new secret that is not enforced until EnableTOTP.
+// It never replaces the secret of an account with 2FA already enabled.
+func (userRepository *UserRepository) SetPendingTOTPSecret(ctx context.Context, id int, encryptedSecret string) error {
+	query := `
+		UPDATE users
+		SET totp_secret = $1,
+			totp_last_step = NULL
+		WHERE id = $2 AND totp_enabled_at IS NULL;`
+	result, err := userRepository.db.Exec(ctx, query, encryptedSecret, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user with id %d and 2FA not enabled %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// EnableTOTP starts enforcing the pending secret
+func (userRepository *UserRepository) EnableTOTP(ctx context.Context, id int) error {
+	query := `
+		UPDATE users
+		SET totp_enabled_at = NOW()
+		WHERE id = $1 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL;`
+	result, err := userRepository.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user with id %d and pending 2FA %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// UseTOTPStep records the time step of an accepted code. It returns false if this
+// or a later step was already used, so the same code cannot log in twice.
+func (userRepository *UserRepository) UseTOTPStep(ctx context.Context, id int, step int64) (bool, error) {
+	query := `
+		UPDATE users
+		SET totp_last_step = $1
+		WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1);`
+	result, err := userRepository.db.Exec(ctx, query, step, id)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// ResetTOTP turns 2FA off and forgets the secret
+func (userRepository *UserRepository) ResetTOTP(ctx context.Context, id int) error {
+	query := `
+		UPDATE users
+		SET totp_secret = NULL,
+			totp_enabled_at = NULL,
+			totp_last_step = NULL
+		WHERE id = $1;`
+	result, err := userRepository.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user with id %d %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// MarkEmailVerified records that the user confirmed their email address
+func (userRepository *UserRepository) MarkEmailVerified(ctx context.Context, id int) error {
+	query := `
+		UPDATE users
+		SET email_verified_at = COALESCE(email_verified_at, NOW())
 		WHERE id = $1;`
+	result, err := userRepository.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user with id %d %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteUser soft deletes the account: it is hidden, every access token stops working and
+// the erasure is scheduled for the given time. Deleting it again keeps the earlier dates.
+// Until the erasure it can be restored (RestoreUser, RestoreDeletedUser).
+// Returns the user and when it will be erased.
+func (userRepository *UserRepository) DeleteUser(ctx context.Context, id int, eraseAt time.Time) (*models.UserWithoutPassword, time.Time, error) {
+	var (
+		user         models.UserWithoutPassword
+		scheduledFor time.Time
+	)
+	query := `
+		UPDATE users
+		SET deleted_at = COALESCE(deleted_at, NOW()),
+			erasure_scheduled_for = COALESCE(erasure_scheduled_for, $2),
+			sessions_revoked_at = NOW()
+		WHERE id = $1 AND erased_at IS NULL
+		RETURNING id, email, name, role, email_verified_at IS NOT NULL, erasure_scheduled_for;`
+	err := userRepository.db.QueryRow(ctx, query, id, eraseAt).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Name,
+		&user.Role,
+		&user.EmailVerified,
+		&scheduledFor,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, time.Time{}, fmt.Errorf("user with id %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return &user, scheduledFor, nil
+}
 
+// DeactivateUser suspends an account that is not deleted: it is hidden and every
+// access token stops working until RestoreUser. Deactivated accounts are never erased.
+func (userRepository *UserRepository) DeactivateUser(ctx context.Context, id int) error {
+	query := `
+		UPDATE users
+		SET deactivated_at = COALESCE(deactivated_at, NOW()),
+			sessions_revoked_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL;`
 	result, err := userRepository.db.Exec(ctx, query, id)
 	if err != nil {
 		return err
 	}
-	rows := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("user with id %d not found", id)
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("user with id %d %w", id, ErrNotFound)
 	}
 	return nil
 }
+
+// RestoreUser reactivates a deactivated or deleted account that is not erased yet
+// and cancels its erasure, ErrNotFound when there is nothing to restore
+func (userRepository *UserRepository) RestoreUser(ctx context.Context, id int) (*models.UserWithoutPassword, error) {
+	var user models.UserWithoutPassword
+	query := `
+		UPDATE users
+		SET deactivated_at = NULL,
+			deleted_at = NULL,
+			erasure_scheduled_for = NULL
+		WHERE id = $1 AND erased_at IS NULL AND (deactivated_at IS NOT NULL OR deleted_at IS NOT NULL)
+		RETURNING id, email, name, role, email_verified_at IS NOT NULL;`
+	err := userRepository.db.QueryRow(ctx, query, id).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Name,
+		&user.Role,
+		&user.EmailVerified,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("inactive user with id %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RestoreDeletedUser redeems the restore link sent when the account was deleted and
+// undoes the deletion in the same transaction. A deactivation by an admin is kept.
+// ErrNotFound for an invalid token or an account that is not deleted (anymore).
+func (userRepository *UserRepository) RestoreDeletedUser(ctx context.Context, tokenHash string) (*models.UserWithoutPassword, error) {
+	tx, err := userRepository.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	token, err := consumeToken(ctx, tx, models.TokenPurposeAccountRestore, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	var user models.UserWithoutPassword
+	query := `
+		UPDATE users
+		SET deleted_at = NULL,
+			erasure_scheduled_for = NULL
+		WHERE id = $1 AND erased_at IS NULL AND deleted_at IS NOT NULL
+		RETURNING id, email, name, role, email_verified_at IS NOT NULL;`
+	err = tx.QueryRow(ctx, query, token.UserID).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Name,
+		&user.Role,
+		&user.EmailVerified,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("deleted user with id %d %w", token.UserID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetDueErasures returns up to limit accounts whose grace period is over
+func (userRepository *UserRepository) GetDueErasures(ctx context.Context, limit int) ([]int, error) {
+	ids := []int{}
+	query := `
+		SELECT id
+		FROM users
+		WHERE erased_at IS NULL AND erasure_scheduled_for <= NOW()
+		ORDER BY erasure_scheduled_for
+		LIMIT $1;`
+	rows, err := userRepository.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// EraseUser completes a due erasure in one transaction. The users row stays, so products
+// and audit events keep pointing at it, but it is pseudonymized: name and email are
+// replaced, the profile is cleared, credentials, sessions, linked accounts, API keys and login history are deleted,
+// and the IP, user agent and emails are cleared from the audit events about the user.
+// Returns the user as it was, ErrNotFound when the erasure is not due (anymore).
+func (userRepository *UserRepository) EraseUser(ctx context.Context, id int) (*models.UserWithoutPassword, error) {
+	tx, err := userRepository.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op after a successful Commit
+	defer tx.Rollback(ctx)
+
+	// locked and checked again: the erasure may have been canceled, or done by another instance
+	var user models.UserWithoutPassword
+	query := `
+		SELECT id, email, name, role, email_verified_at IS NOT NULL
+		FROM users
+		WHERE id = $1 AND erased_at IS NULL AND erasure_scheduled_for <= NOW()
+		FOR UPDATE;`
+	err = tx.QueryRow(ctx, query, id).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Name,
+		&user.Role,
+		&user.EmailVerified,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("erasure of user %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the only allowed change of audit_events, see audit_events_append_only in sql/init.sql;
+	// set_config(..., true) lasts until the end of this transaction
+	if _, err := tx.Exec(ctx, `SELECT set_config('app.audit_pseudonymize', 'on', true);`); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE audit_events
+		SET ip = '', user_agent = '', details = details - 'email' - 'new_email' - 'error'
+		WHERE actor_id = $1
+		   OR (target_type = 'user' AND target_id = $1)
+		   OR details->>'email' = $2
+		   OR details->>'new_email' = $2;`, id, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []string{
+		`DELETE FROM refresh_tokens WHERE user_id = $1;`,
+		`DELETE FROM one_time_tokens WHERE user_id = $1;`,
+		`DELETE FROM recovery_codes WHERE user_id = $1;`,
+		`DELETE FROM identities WHERE user_id = $1;`,
+		`DELETE FROM oidc_login_states WHERE link_user_id = $1;`,
+		`DELETE FROM api_keys WHERE user_id = $1;`,
+		`DELETE FROM logins WHERE user_id = $1;`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement, id); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM login_attempts WHERE scope = 'account' AND key = LOWER($1);`, user.Email); err != nil {
+		return nil, err
+	}
+
+	// "erased-5@erased" has no TLD, so no one can register or sign in with it
+	_, err = tx.Exec(ctx, `
+		UPDATE users
+		SET email = 'erased-' || id || '@erased',
+			name = 'Deleted user',
+			hashed_password = '',
+			role = 'user',
+			email_verified_at = NULL,
+			sessions_revoked_at = NOW(),
+			totp_secret = NULL,
+			totp_enabled_at = NULL,
+			totp_last_step = NULL,
+			display_name = NULL,
+			handle = NULL,
+			bio = NULL,
+			avatar_url = NULL,
+			status_text = NULL,
+			status_emoji = NULL,
+			last_seen_at = NULL,
+			erasure_scheduled_for = NULL,
+			erased_at = NOW()
+		WHERE id = $1;`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
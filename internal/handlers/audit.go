@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"lesson-proj/internal/models"
+	services "lesson-proj/internal/services/audit"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AuditHandler struct {
+	service *services.AuditService
+}
+
+func NewAuditHandler(service *services.AuditService) *AuditHandler {
+	return &AuditHandler{
+		service: service,
+	}
+}
+
+// GetAuditEvents returns a page of events, newest first.
+// Query: user_id, type (comma separated), outcome, from, to (RFC 3339), before_id, limit.
+// The next page is requested with before_id set to the last id of this one.
+func (handler *AuditHandler) GetAuditEvents(response http.ResponseWriter, request *http.Request) {
+	filter, err := parseAuditFilter(request.URL.Query())
+	if err != nil {
+		respondWithError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	events, err := handler.service.GetEvents(request.Context(), filter)
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to retrieve audit events")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, events)
+}
+
+// ExportAuditEvents streams every matching event as JSON Lines, same filters as GetAuditEvents
+func (handler *AuditHandler) ExportAuditEvents(response http.ResponseWriter, request *http.Request) {
+	filter, err := parseAuditFilter(request.URL.Query())
+	if err != nil {
+		respondWithError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	// a large export takes longer than the server write timeout
+	if err := http.NewResponseController(response).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("failed to lift write deadline for audit export: %v", err)
+	}
+
+	writer := &exportWriter{response: response}
+	err = handler.service.Export(request.Context(), filter, writer)
+	if err == nil {
+		if !writer.started {
+			writer.start()
+		}
+		return
+	}
+	// once the stream started the status is sent, the client sees a truncated file
+	if writer.started {
+		log.Printf("audit export interrupted: %v", err)
+		return
+	}
+	respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to export audit events")
+}
+
+// exportWriter sends the headers with the first line, so errors before it still get a JSON answer
+type exportWriter struct {
+	response http.ResponseWriter
+	started  bool
+}
+
+func (writer *exportWriter) start() {
+	writer.started = true
+	writer.response.Header().Set("Content-Type", "application/x-ndjson")
+	writer.response.Header().Set("Content-Disposition", `attachment; filename="audit-events.jsonl"`)
+	writer.response.WriteHeader(http.StatusOK)
+}
+
+func (writer *exportWriter) Write(p []byte) (int, error) {
+	if !writer.started {
+		writer.start()
+	}
+	return writer.response.Write(p)
+}
+
+func parseAuditFilter(query url.Values) (models.AuditEventFilter, error) {
+	var filter models.AuditEventFilter
+	if value := query.Get("user_id"); value != "" {
+		userID, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, errors.New("Invalid user_id")
+		}
+		filter.UserID = &userID
+	}
+	if value := query.Get("type"); value != "" {
+		for _, eventType := range strings.Split(value, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				filter.Types = append(filter.Types, eventType)
+			}
+		}
+	}
+	if value := query.Get("outcome"); value != "" {
+		switch value {
+		case models.AuditOutcomeSuccess, models.AuditOutcomeFailure, models.AuditOutcomeDenied:
+			filter.Outcome = value
+		default:
+			return filter, errors.New("Invalid outcome")
+		}
+	}
+	for name, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, errors.New("Invalid " + name + ", expected RFC 3339")
+			}
+			*target = &parsed
+		}
+	}
+	if value := query.Get("before_id"); value != "" {
+		beforeID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return filter, errors.New("Invalid before_id")
+		}
+		filter.BeforeID = &beforeID
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return filter, errors.New("Invalid limit")
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
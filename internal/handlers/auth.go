@@ -1,11 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"lesson-proj/internal/authctx"
 	"lesson-proj/internal/models"
 	services "lesson-proj/internal/services/auth"
+	authUtils "lesson-proj/internal/services/auth/utils"
 
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type UserHandler struct {
@@ -27,13 +34,25 @@ func (handler *UserHandler) Registration(response http.ResponseWriter, request *
 		return
 	}
 
-	createdUser, err := handler.service.Registration(request.Context(), input)
+	// the response is the same whether the email was free or already registered
+	err := handler.service.Registration(request.Context(), input)
+	if errors.Is(err, authUtils.ErrHashPoolFull) {
+		respondWithOverloaded(response)
+		return
+	}
+	var validationError *authUtils.ValidationError
+	if errors.As(err, &validationError) {
+		respondWithValidationError(response, validationError)
+		return
+	}
 	if err != nil {
 		respondWithError(response, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	respondWithJSON(response, http.StatusCreated, createdUser)
+	respondWithJSON(response, http.StatusAccepted, map[string]string{
+		"message": "Check your email to confirm your account",
+	})
 }
 
 func (handler *UserHandler) Authorization(response http.ResponseWriter, request *http.Request) {
@@ -45,18 +64,326 @@ func (handler *UserHandler) Authorization(response http.ResponseWriter, request
 	}
 	// context from request used to pass deadlines, cancelation signals, and other request-scoped values
 	user, err := handler.service.Authorization(request.Context(), authUser.Email, authUser.Password)
+	// correct password, the client must send a TOTP or recovery code to /users/auth/2fa
+	var twoFactorRequired *services.TwoFactorRequiredError
+	if errors.As(err, &twoFactorRequired) {
+		respondWithJSON(response, http.StatusOK, twoFactorRequired.Challenge)
+		return
+	}
+	var tooManyAttempts *services.TooManyAttemptsError
+	if errors.As(err, &tooManyAttempts) {
+		// Retry-After in whole seconds, rounded up
+		retryAfter := int(math.Ceil(tooManyAttempts.RetryAfter.Seconds()))
+		response.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		respondWithError(response, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		respondWithError(response, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if errors.Is(err, authUtils.ErrHashPoolFull) {
+		respondWithOverloaded(response)
+		return
+	}
 	if err != nil {
+		respondWithError(response, http.StatusInternalServerError, "Failed to authorize")
+		return
+	}
+
+	respondWithJSON(response, http.StatusOK, user)
+}
+
+func (handler *UserHandler) CompleteTwoFactorLogin(response http.ResponseWriter, request *http.Request) {
+	var input models.TwoFactorLogin
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	user, err := handler.service.CompleteTwoFactorLogin(request.Context(), input)
+	var tooManyAttempts *services.TooManyAttemptsError
+	if errors.As(err, &tooManyAttempts) {
+		retryAfter := int(math.Ceil(tooManyAttempts.RetryAfter.Seconds()))
+		response.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		respondWithError(response, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrInvalidTwoFactorCode) {
 		respondWithError(response, http.StatusUnauthorized, err.Error())
 		return
 	}
+	if err != nil {
+		respondWithError(response, http.StatusInternalServerError, "Failed to authorize")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, user)
+}
+
+func (handler *UserHandler) StartOIDCLogin(response http.ResponseWriter, request *http.Request) {
+	var input models.StartOIDCLogin
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	authorization, err := handler.service.StartOIDCLogin(request.Context(), input.Provider)
+	if errors.Is(err, services.ErrUnknownProvider) {
+		respondWithError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(response, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, authorization)
+}
 
+func (handler *UserHandler) CompleteOIDCLogin(response http.ResponseWriter, request *http.Request) {
+	var input models.OIDCCallback
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	user, err := handler.service.CompleteOIDCLogin(request.Context(), input)
+	var twoFactorRequired *services.TwoFactorRequiredError
+	if errors.As(err, &twoFactorRequired) {
+		respondWithJSON(response, http.StatusOK, twoFactorRequired.Challenge)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrExternalLoginFailed) {
+		respondWithError(response, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrAccountExists) {
+		respondWithError(response, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrExternalEmailRequired) || errors.Is(err, services.ErrUnknownProvider) {
+		respondWithError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to authorize")
+		return
+	}
 	respondWithJSON(response, http.StatusOK, user)
 }
 
+// RequestMagicLink always answers 202 with the same body, whether the email is registered or not
+func (handler *UserHandler) RequestMagicLink(response http.ResponseWriter, request *http.Request) {
+	var input models.RequestMagicLink
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	requested, err := handler.service.RequestMagicLink(request.Context(), input.Email)
+	if err != nil {
+		respondWithError(response, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+	respondWithJSON(response, http.StatusAccepted, requested)
+}
+
+func (handler *UserHandler) RedeemMagicLink(response http.ResponseWriter, request *http.Request) {
+	var input models.RedeemMagicLink
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	user, err := handler.service.RedeemMagicLink(request.Context(), input)
+	var twoFactorRequired *services.TwoFactorRequiredError
+	if errors.As(err, &twoFactorRequired) {
+		respondWithJSON(response, http.StatusOK, twoFactorRequired.Challenge)
+		return
+	}
+	var tooManyAttempts *services.TooManyAttemptsError
+	if errors.As(err, &tooManyAttempts) {
+		retryAfter := int(math.Ceil(tooManyAttempts.RetryAfter.Seconds()))
+		response.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		respondWithError(response, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrInvalidToken) {
+		respondWithError(response, http.StatusUnauthorized, "Invalid or expired sign-in link")
+		return
+	}
+	if errors.Is(err, services.ErrMagicLinkWrongDevice) {
+		respondWithError(response, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to authorize")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, user)
+}
+
+func (handler *UserHandler) RefreshTokens(response http.ResponseWriter, request *http.Request) {
+	var input models.RefreshRequest
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	tokens, err := handler.service.RefreshTokens(request.Context(), input.RefreshToken)
+	if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+		respondWithError(response, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(response, http.StatusInternalServerError, "Failed to refresh tokens")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, tokens)
+}
+
+func (handler *UserHandler) Logout(response http.ResponseWriter, request *http.Request) {
+	var input models.RefreshRequest
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	err := handler.service.Logout(request.Context(), input.RefreshToken)
+	if errors.Is(err, services.ErrInvalidToken) {
+		respondWithError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(response, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+	respondWithJSON(response, http.StatusNoContent, nil)
+}
+
+// LogoutAll revokes every session of the authenticated user ("log out all devices")
+func (handler *UserHandler) LogoutAll(response http.ResponseWriter, request *http.Request) {
+	userID, _ := authctx.UserID(request.Context())
+	if err := handler.service.LogoutAll(request.Context(), userID); err != nil {
+		respondWithError(response, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+	respondWithJSON(response, http.StatusNoContent, nil)
+}
+
+func (handler *UserHandler) VerifyEmail(response http.ResponseWriter, request *http.Request) {
+	var input models.VerifyEmail
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	err := handler.service.VerifyEmail(request.Context(), input.Token)
+	if errors.Is(err, services.ErrInvalidToken) {
+		respondWithError(response, http.StatusBadRequest, "Invalid or expired verification token")
+		return
+	}
+	if err != nil {
+		respondWithError(response, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+	respondWithJSON(response, http.StatusNoContent, nil)
+}
+
+// RequestEmailChange always answers 202 once the password is correct,
+// whether the new address is free or not
+func (handler *UserHandler) RequestEmailChange(response http.ResponseWriter, request *http.Request) {
+	var input models.ChangeEmail
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	err := handler.service.RequestEmailChange(request.Context(), input)
+	var tooManyAttempts *services.TooManyAttemptsError
+	if errors.As(err, &tooManyAttempts) {
+		retryAfter := int(math.Ceil(tooManyAttempts.RetryAfter.Seconds()))
+		response.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		respondWithError(response, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrInvalidPassword) {
+		respondWithError(response, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to request email change")
+		return
+	}
+	respondWithJSON(response, http.StatusAccepted, map[string]string{
+		"message": "Check the new address to confirm the change",
+	})
+}
+
+func (handler *UserHandler) ConfirmEmailChange(response http.ResponseWriter, request *http.Request) {
+	handler.redeemEmailChange(response, request, handler.service.ConfirmEmailChange)
+}
+
+func (handler *UserHandler) CancelEmailChange(response http.ResponseWriter, request *http.Request) {
+	handler.redeemEmailChange(response, request, handler.service.CancelEmailChange)
+}
+
+// redeemEmailChange handles the confirmation and cancel links, both post {"token": "..."}
+func (handler *UserHandler) redeemEmailChange(response http.ResponseWriter, request *http.Request, redeem func(context.Context, string) error) {
+	var input models.EmailChangeToken
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	err := redeem(request.Context(), input.Token)
+	if errors.Is(err, services.ErrInvalidToken) {
+		respondWithError(response, http.StatusBadRequest, "Invalid or expired link")
+		return
+	}
+	if errors.Is(err, services.ErrEmailTaken) {
+		respondWithError(response, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to change email")
+		return
+	}
+	respondWithJSON(response, http.StatusNoContent, nil)
+}
+
+func (handler *UserHandler) ResendVerification(response http.ResponseWriter, request *http.Request) {
+	err := handler.service.ResendVerification(request.Context())
+	if errors.Is(err, services.ErrEmailAlreadyVerified) {
+		respondWithError(response, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+	respondWithJSON(response, http.StatusAccepted, nil)
+}
+
+// HashPoolStats returns password hashing queue metrics (admin)
+func (handler *UserHandler) HashPoolStats(response http.ResponseWriter, request *http.Request) {
+	stats, err := handler.service.HashPoolStats(request.Context())
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to retrieve metrics")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, stats)
+}
+
 func (handler *UserHandler) GetAllUsers(response http.ResponseWriter, request *http.Request) {
-	users, err := handler.service.GetAllUsers(request.Context())
+	// optional: /users?include_inactive=true also lists deactivated and deleted accounts
+	var filter models.UserFilter
+	if includeParam := request.URL.Query().Get("include_inactive"); includeParam != "" {
+		include, err := strconv.ParseBool(includeParam)
+		if err != nil {
+			respondWithError(response, http.StatusBadRequest, "Invalid include_inactive")
+			return
+		}
+		filter.IncludeInactive = include
+	}
+	users, err := handler.service.GetAllUsers(request.Context(), filter)
 	if err != nil {
-		respondWithError(response, http.StatusInternalServerError, "Failed to retrieve users")
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to retrieve users")
 		return
 	}
 	respondWithJSON(response, http.StatusOK, users)
@@ -71,7 +398,7 @@ func (handler *UserHandler) GetUserByID(response http.ResponseWriter, request *h
 	}
 	user, err := handler.service.GetUserByID(request.Context(), id)
 	if err != nil {
-		respondWithError(response, http.StatusInternalServerError, "Failed to retrieve user")
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to retrieve user")
 		return
 	}
 	if user == nil {
@@ -95,8 +422,319 @@ func (handler *UserHandler) UpdateUser(response http.ResponseWriter, request *ht
 	}
 
 	updatedUser, err := handler.service.UpdateUser(request.Context(), id, userInput)
+	if errors.Is(err, services.ErrUseChangePassword) || errors.Is(err, services.ErrUseEmailChange) {
+		respondWithError(response, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
-		respondWithError(response, http.StatusInternalServerError, "Failed to update user")
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, updatedUser)
+}
+
+// ChangePassword changes the caller's own password, the current password is required
+func (handler *UserHandler) ChangePassword(response http.ResponseWriter, request *http.Request) {
+	id, err := getIDFromPath(request)
+	if err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	var input models.ChangePassword
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	err = handler.service.ChangePassword(request.Context(), id, input.CurrentPassword, input.NewPassword)
+	var tooManyAttempts *services.TooManyAttemptsError
+	if errors.As(err, &tooManyAttempts) {
+		retryAfter := int(math.Ceil(tooManyAttempts.RetryAfter.Seconds()))
+		response.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		respondWithError(response, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrInvalidPassword) {
+		respondWithError(response, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+	respondWithJSON(response, http.StatusNoContent, nil)
+}
+
+// ForgotPassword always answers 202 with the same body, whether the email is registered or not
+func (handler *UserHandler) ForgotPassword(response http.ResponseWriter, request *http.Request) {
+	var input models.ForgotPassword
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := handler.service.ForgotPassword(request.Context(), input.Email); err != nil {
+		respondWithError(response, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+	respondWithJSON(response, http.StatusAccepted, map[string]string{
+		"message": "If an account with this email exists, a password reset link has been sent",
+	})
+}
+
+func (handler *UserHandler) ResetPassword(response http.ResponseWriter, request *http.Request) {
+	var input models.ResetPassword
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	err := handler.service.ResetPassword(request.Context(), input.Token, input.NewPassword)
+	if errors.Is(err, services.ErrInvalidToken) {
+		respondWithError(response, http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+	if errors.Is(err, authUtils.ErrHashPoolFull) {
+		respondWithOverloaded(response)
+		return
+	}
+	var validationError *authUtils.ValidationError
+	if errors.As(err, &validationError) {
+		respondWithValidationError(response, validationError)
+		return
+	}
+	if err != nil {
+		respondWithError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(response, http.StatusNoContent, nil)
+}
+
+// EnrollTwoFactor starts 2FA enrollment for the caller after checking their password
+func (handler *UserHandler) EnrollTwoFactor(response http.ResponseWriter, request *http.Request) {
+	var input models.EnrollTwoFactor
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	enrollment, err := handler.service.EnrollTwoFactor(request.Context(), input.Password)
+	if errors.Is(err, services.ErrInvalidPassword) {
+		respondWithError(response, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrTwoFactorAlreadyEnabled) {
+		respondWithError(response, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrTwoFactorUnavailable) {
+		respondWithError(response, http.StatusNotImplemented, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to enroll two-factor authentication")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, enrollment)
+}
+
+func (handler *UserHandler) ConfirmTwoFactor(response http.ResponseWriter, request *http.Request) {
+	var input models.ConfirmTwoFactor
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	recoveryCodes, err := handler.service.ConfirmTwoFactor(request.Context(), input.Code)
+	if errors.Is(err, services.ErrInvalidTwoFactorCode) || errors.Is(err, services.ErrTwoFactorNotEnrolled) {
+		respondWithError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrTwoFactorAlreadyEnabled) {
+		respondWithError(response, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrTwoFactorUnavailable) {
+		respondWithError(response, http.StatusNotImplemented, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to confirm two-factor authentication")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, recoveryCodes)
+}
+
+func (handler *UserHandler) ResetTwoFactor(response http.ResponseWriter, request *http.Request) {
+	id, err := getIDFromPath(request)
+	if err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	if err := handler.service.ResetTwoFactor(request.Context(), id); err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to reset two-factor authentication")
+		return
+	}
+	respondWithJSON(response, http.StatusNoContent, nil)
+}
+
+func (handler *UserHandler) StartOIDCLink(response http.ResponseWriter, request *http.Request) {
+	var input models.StartOIDCLogin
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	authorization, err := handler.service.StartOIDCLink(request.Context(), input.Provider)
+	if errors.Is(err, services.ErrUnknownProvider) {
+		respondWithError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to start linking")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, authorization)
+}
+
+func (handler *UserHandler) CompleteOIDCLink(response http.ResponseWriter, request *http.Request) {
+	var input models.OIDCCallback
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	identity, err := handler.service.CompleteOIDCLink(request.Context(), input)
+	if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrExternalLoginFailed) {
+		respondWithError(response, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrIdentityAlreadyLinked) {
+		respondWithError(response, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrUnknownProvider) {
+		respondWithError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to link account")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, identity)
+}
+
+func (handler *UserHandler) GetIdentities(response http.ResponseWriter, request *http.Request) {
+	identities, err := handler.service.GetIdentities(request.Context())
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to get linked accounts")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, identities)
+}
+
+func (handler *UserHandler) GetSessions(response http.ResponseWriter, request *http.Request) {
+	sessions, err := handler.service.GetSessions(request.Context())
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to get sessions")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, sessions)
+}
+
+func (handler *UserHandler) GetLogins(response http.ResponseWriter, request *http.Request) {
+	logins, err := handler.service.GetLogins(request.Context())
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to get login history")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, logins)
+}
+
+func (handler *UserHandler) UnlinkIdentity(response http.ResponseWriter, request *http.Request) {
+	// /users/identities/{provider}
+	provider := strings.TrimPrefix(request.URL.Path, "/users/identities/")
+	if provider == "" || strings.Contains(provider, "/") {
+		respondWithError(response, http.StatusBadRequest, "Invalid provider")
+		return
+	}
+	if err := handler.service.UnlinkIdentity(request.Context(), provider); err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to unlink account")
+		return
+	}
+	respondWithJSON(response, http.StatusNoContent, nil)
+}
+
+func (handler *UserHandler) CreateAPIKey(response http.ResponseWriter, request *http.Request) {
+	var input models.CreateAPIKey
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	created, err := handler.service.CreateAPIKey(request.Context(), input)
+	if errors.Is(err, services.ErrTooManyAPIKeys) {
+		respondWithError(response, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+	respondWithJSON(response, http.StatusCreated, created)
+}
+
+func (handler *UserHandler) GetAPIKeys(response http.ResponseWriter, request *http.Request) {
+	keys, err := handler.service.GetAPIKeys(request.Context())
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to get API keys")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, keys)
+}
+
+func (handler *UserHandler) RevokeAPIKey(response http.ResponseWriter, request *http.Request) {
+	// /users/api-keys/{id}
+	id, err := strconv.Atoi(strings.TrimPrefix(request.URL.Path, "/users/api-keys/"))
+	if err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+	if err := handler.service.RevokeAPIKey(request.Context(), id); err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+	respondWithJSON(response, http.StatusNoContent, nil)
+}
+
+// UnlockAccount clears the login lockout of a user, admins only
+func (handler *UserHandler) UnlockAccount(response http.ResponseWriter, request *http.Request) {
+	id, err := getIDFromPath(request)
+	if err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	if err := handler.service.UnlockAccount(request.Context(), id); err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to unlock account")
+		return
+	}
+	respondWithJSON(response, http.StatusNoContent, nil)
+}
+
+func (handler *UserHandler) UpdateUserRole(response http.ResponseWriter, request *http.Request) {
+	id, err := getIDFromPath(request)
+	if err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	var input models.UpdateUserRole
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	updatedUser, err := handler.service.UpdateUserRole(request.Context(), id, input.Role)
+	if errors.Is(err, services.ErrCannotDemoteSelf) {
+		respondWithError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to update user role")
 		return
 	}
 	respondWithJSON(response, http.StatusOK, updatedUser)
@@ -108,9 +746,61 @@ func (handler *UserHandler) DeleteUser(response http.ResponseWriter, request *ht
 		respondWithError(response, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
-	err = handler.service.DeleteUser(request.Context(), id)
+	// soft delete, the account is erased after a grace period unless restored
+	scheduled, err := handler.service.DeleteUser(request.Context(), id)
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to delete user")
+		return
+	}
+	respondWithJSON(response, http.StatusAccepted, scheduled)
+}
+
+func (handler *UserHandler) DeactivateUser(response http.ResponseWriter, request *http.Request) {
+	id, err := getIDFromPath(request)
+	if err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	err = handler.service.DeactivateUser(request.Context(), id)
+	if errors.Is(err, services.ErrCannotDeactivateSelf) {
+		respondWithError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to deactivate user")
+		return
+	}
+	respondWithJSON(response, http.StatusNoContent, nil)
+}
+
+func (handler *UserHandler) RestoreUser(response http.ResponseWriter, request *http.Request) {
+	id, err := getIDFromPath(request)
+	if err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	restoredUser, err := handler.service.RestoreUser(request.Context(), id)
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to restore user")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, restoredUser)
+}
+
+// RestoreDeletedAccount redeems the restore link from the deletion notice, posts {"token": "..."}
+func (handler *UserHandler) RestoreDeletedAccount(response http.ResponseWriter, request *http.Request) {
+	var input models.RestoreAccount
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	err := handler.service.RestoreDeletedAccount(request.Context(), input.Token)
+	if errors.Is(err, services.ErrInvalidToken) {
+		respondWithError(response, http.StatusBadRequest, "Invalid or expired link")
+		return
+	}
 	if err != nil {
-		respondWithError(response, http.StatusInternalServerError, "Failed to delete user")
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to restore account")
 		return
 	}
 	respondWithJSON(response, http.StatusNoContent, nil)
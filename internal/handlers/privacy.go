@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"lesson-proj/internal/models"
+	"net/http"
+)
+
+// ExportUserData serves the caller's data as one JSON document, or with ?format=zip
+// as a ZIP archive with one JSON file per section
+func (handler *UserHandler) ExportUserData(response http.ResponseWriter, request *http.Request) {
+	format := request.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		respondWithError(response, http.StatusBadRequest, "Invalid format, use json or zip")
+		return
+	}
+	export, err := handler.service.ExportUserData(request.Context())
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to export data")
+		return
+	}
+
+	filename := fmt.Sprintf("user-%d-data-%s", export.Profile.ID, export.ExportedAt.Format("20060102"))
+	if format != "zip" {
+		response.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		respondWithJSON(response, http.StatusOK, export)
+		return
+	}
+
+	// built in memory: an error must still be able to become a 500
+	archive, err := zipExport(export)
+	if err != nil {
+		respondWithError(response, http.StatusInternalServerError, "Failed to export data")
+		return
+	}
+	response.Header().Set("Content-Type", "application/zip")
+	response.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	response.WriteHeader(http.StatusOK)
+	response.Write(archive)
+}
+
+func zipExport(export *models.DataExport) ([]byte, error) {
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"profile.json", map[string]any{
+			"exported_at":        export.ExportedAt,
+			"profile":            export.Profile,
+			"two_factor_enabled": export.TwoFactorEnabled,
+		}},
+		{"linked_accounts.json", export.Identities},
+		{"api_keys.json", export.APIKeys},
+		{"logins.json", export.Logins},
+		{"products.json", export.Products},
+		{"audit_events.json", export.AuditEvents},
+	}
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for _, file := range files {
+		writer, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
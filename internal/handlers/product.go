@@ -5,6 +5,7 @@ import (
 	"lesson-proj/internal/models"
 	services "lesson-proj/internal/services/products"
 	"net/http"
+	"strconv"
 )
 
 type ProductHandler struct {
@@ -20,9 +21,29 @@ func NewProductHandler(service *services.ProductService) *ProductHandler {
 }
 
 func (handler *ProductHandler) GetAllProducts(response http.ResponseWriter, request *http.Request) {
-	products, err := handler.service.GetAllProducts(request.Context())
+	// optional filter: /products?seller_id=5
+	var filter models.ProductFilter
+	if sellerIDParam := request.URL.Query().Get("seller_id"); sellerIDParam != "" {
+		sellerID, err := strconv.Atoi(sellerIDParam)
+		if err != nil {
+			respondWithError(response, http.StatusBadRequest, "Invalid seller_id")
+			return
+		}
+		filter.SellerID = &sellerID
+	}
+	// admins only: /products?include_deleted=true
+	if includeParam := request.URL.Query().Get("include_deleted"); includeParam != "" {
+		include, err := strconv.ParseBool(includeParam)
+		if err != nil {
+			respondWithError(response, http.StatusBadRequest, "Invalid include_deleted")
+			return
+		}
+		filter.IncludeDeleted = include
+	}
+
+	products, err := handler.service.GetAllProducts(request.Context(), filter)
 	if err != nil {
-		respondWithError(response, http.StatusInternalServerError, "Failed to retrieve products")
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to retrieve products")
 		return
 	}
 	respondWithJSON(response, http.StatusOK, products)
@@ -37,7 +58,7 @@ func (handler *ProductHandler) GetProductByID(response http.ResponseWriter, requ
 	}
 	product, err := handler.service.GetProductByID(request.Context(), id)
 	if err != nil {
-		respondWithError(response, http.StatusInternalServerError, "Failed to retrieve product")
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to retrieve product")
 		return
 	}
 	if product == nil {
@@ -59,7 +80,7 @@ func (handler *ProductHandler) CreateProduct(response http.ResponseWriter, reque
 	
 	createdProduct, err := handler.service.CreateProduct(request.Context(), productInput)
 	if err != nil {
-		respondWithError(response, http.StatusInternalServerError, "Failed to create product")
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to create product")
 		return
 	}
 	respondWithJSON(response, http.StatusCreated, createdProduct)
@@ -81,7 +102,7 @@ func (handler *ProductHandler) UpdateProduct(response http.ResponseWriter, reque
 	
 	updatedProduct, err := handler.service.UpdateProduct(request.Context(), id, productInput)
 	if err != nil {
-		respondWithError(response, http.StatusInternalServerError, "Failed to update product")
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to update product")
 		return
 	}
 	respondWithJSON(response, http.StatusOK, updatedProduct)
@@ -95,8 +116,22 @@ func (handler *ProductHandler) DeleteProduct(response http.ResponseWriter, reque
 	}
 	err = handler.service.DeleteProduct(request.Context(), id)
 	if err != nil {
-		respondWithError(response, http.StatusInternalServerError, "Failed to delete product")
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to delete product")
 		return
 	}
 	respondWithJSON(response, http.StatusNoContent, nil)
 }
+
+func (handler *ProductHandler) RestoreProduct(response http.ResponseWriter, request *http.Request) {
+	id, err := getIDFromPath(request)
+	if err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid product ID")
+		return
+	}
+	product, err := handler.service.RestoreProduct(request.Context(), id)
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to restore product")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, product)
+}
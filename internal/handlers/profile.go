@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"lesson-proj/internal/models"
+	services "lesson-proj/internal/services/auth"
+	"net/http"
+	"strings"
+)
+
+func (handler *UserHandler) GetProfile(response http.ResponseWriter, request *http.Request) {
+	profile, err := handler.service.GetProfile(request.Context())
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to retrieve profile")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, profile)
+}
+
+func (handler *UserHandler) UpdateProfile(response http.ResponseWriter, request *http.Request) {
+	var input models.UpdateProfile
+	if err := json.NewDecoder(request.Body).Decode(&input); err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	profile, err := handler.service.UpdateProfile(request.Context(), input)
+	if errors.Is(err, services.ErrHandleTaken) {
+		respondWithError(response, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to update profile")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, profile)
+}
+
+// GetPublicProfile serves /users/{id}/profile
+func (handler *UserHandler) GetPublicProfile(response http.ResponseWriter, request *http.Request) {
+	id, err := getIDFromPath(request)
+	if err != nil {
+		respondWithError(response, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	profile, err := handler.service.GetPublicProfile(request.Context(), id)
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to retrieve profile")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, profile)
+}
+
+// GetPublicProfileByHandle serves /profiles/{handle}, with or without the "@"
+func (handler *UserHandler) GetPublicProfileByHandle(response http.ResponseWriter, request *http.Request) {
+	handle := strings.Trim(strings.TrimPrefix(request.URL.Path, "/profiles/"), "/")
+	if handle == "" {
+		respondWithError(response, http.StatusBadRequest, "Invalid handle")
+		return
+	}
+	profile, err := handler.service.GetPublicProfileByHandle(request.Context(), handle)
+	if err != nil {
+		respondWithServiceError(response, err, http.StatusInternalServerError, "Failed to retrieve profile")
+		return
+	}
+	respondWithJSON(response, http.StatusOK, profile)
+}
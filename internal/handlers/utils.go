@@ -3,6 +3,9 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"lesson-proj/internal/database"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"lesson-proj/internal/services/permissions"
 	"net/http"
 	"strconv"
 	"strings"
@@ -27,6 +30,41 @@ func respondWithError(response http.ResponseWriter, statusCode int, message stri
 	respondWithJSON(response, statusCode, map[string]string{"error": message})
 }
 
+// respondWithServiceError maps well-known service errors to HTTP status codes,
+// any other error is answered with fallbackStatus and fallbackMessage
+func respondWithServiceError(response http.ResponseWriter, err error, fallbackStatus int, fallbackMessage string) {
+	var validationError *authUtils.ValidationError
+	switch {
+	case errors.As(err, &validationError):
+		respondWithValidationError(response, validationError)
+	case errors.Is(err, permissions.ErrUnauthenticated):
+		respondWithError(response, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, permissions.ErrForbidden):
+		respondWithError(response, http.StatusForbidden, err.Error())
+	case errors.Is(err, database.ErrNotFound):
+		respondWithError(response, http.StatusNotFound, err.Error())
+	case errors.Is(err, authUtils.ErrHashPoolFull):
+		respondWithOverloaded(response)
+	default:
+		respondWithError(response, fallbackStatus, fallbackMessage)
+	}
+}
+
+// respondWithValidationError answers 400 with a message per invalid field:
+// {"error": "...", "fields": {"password": "..."}}
+func respondWithValidationError(response http.ResponseWriter, validationError *authUtils.ValidationError) {
+	respondWithJSON(response, http.StatusBadRequest, map[string]any{
+		"error":  validationError.Error(),
+		"fields": validationError.Fields,
+	})
+}
+
+// respondWithOverloaded answers 503 when the server sheds load
+func respondWithOverloaded(response http.ResponseWriter) {
+	response.Header().Set("Retry-After", "1")
+	respondWithError(response, http.StatusServiceUnavailable, authUtils.ErrHashPoolFull.Error())
+}
+
 func getIDFromPath(request *http.Request) (int, error) {
 	// Extract the product ID from the URL path
 	// Example URL path: /products/123
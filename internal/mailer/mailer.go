@@ -0,0 +1,161 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends account emails (verification, password reset, ...).
+// SMTPMailer is used in production, LogMailer for local development and tests.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPMailer — factory function (constructor).
+// username/password may be empty for servers without authentication (e.g. MailHog).
+func NewSMTPMailer(host, port, username, password, from string) (*SMTPMailer, error) {
+	if host == "" || port == "" || from == "" {
+		return nil, errors.New("smtp host, port and from address are required")
+	}
+	return &SMTPMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}, nil
+}
+
+func (mailer *SMTPMailer) Send(ctx context.Context, message Message) error {
+	if err := validateMessage(message); err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if mailer.username != "" {
+		auth = smtp.PlainAuth("", mailer.username, mailer.password, mailer.host)
+	}
+
+	// smtp.SendMail does not accept a context, run it in a goroutine
+	// so a slow SMTP server cannot block the request past its deadline
+	result := make(chan error, 1)
+	go func() {
+		result <- smtp.SendMail(
+			mailer.host+":"+mailer.port,
+			auth,
+			mailer.from,
+			[]string{message.To},
+			buildMIME(mailer.from, message),
+		)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LogMailer does not send anything: messages are written to the log,
+// or appended to a file when path is set (handy to grab links in tests)
+type LogMailer struct {
+	path string
+	// protects the file from concurrent appends
+	mutex sync.Mutex
+}
+
+func NewLogMailer(path string) *LogMailer {
+	return &LogMailer{
+		path: path,
+	}
+}
+
+func (mailer *LogMailer) Send(ctx context.Context, message Message) error {
+	if err := validateMessage(message); err != nil {
+		return err
+	}
+	if mailer.path == "" {
+		log.Printf("mail to=%s subject=%q\n%s", message.To, message.Subject, message.Body)
+		return nil
+	}
+
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	file, err := os.OpenFile(mailer.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "--- %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().UTC().Format(time.RFC3339), message.To, message.Subject, message.Body)
+	return err
+}
+
+// NewFromEnv builds the Mailer selected by MAIL_DRIVER: "smtp" or "log".
+// There is no default: the log mailer writes the links in emails, which are live
+// credentials (password reset, sign-in), to the log, so it has to be chosen on purpose.
+func NewFromEnv() (Mailer, error) {
+	switch os.Getenv("MAIL_DRIVER") {
+	case "":
+		return nil, errors.New(`MAIL_DRIVER is not set, use "smtp" (or "log" for local development only)`)
+	case "smtp":
+		return NewSMTPMailer(
+			os.Getenv("SMTP_HOST"),
+			os.Getenv("SMTP_PORT"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+			os.Getenv("MAIL_FROM"),
+		)
+	case "log":
+		return NewLogMailer(os.Getenv("MAIL_LOG_FILE")), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", os.Getenv("MAIL_DRIVER"))
+	}
+}
+
+func validateMessage(message Message) error {
+	if strings.TrimSpace(message.To) == "" {
+		return errors.New("mail recipient is empty")
+	}
+	// a newline in a header value would let the caller inject extra headers
+	if strings.ContainsAny(message.To+message.Subject, "\r\n") {
+		return errors.New("mail header contains a line break")
+	}
+	return nil
+}
+
+func buildMIME(from string, message Message) []byte {
+	var builder strings.Builder
+	builder.WriteString("From: " + from + "\r\n")
+	builder.WriteString("To: " + message.To + "\r\n")
+	builder.WriteString("Subject: " + message.Subject + "\r\n")
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return []byte(builder.String())
+}
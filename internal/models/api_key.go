@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// API key scopes
+const (
+	ScopeProductsRead  = "products:read"
+	ScopeProductsWrite = "products:write"
+	// reserved for the messaging API
+	ScopeMessagesSend = "messages:send"
+)
+
+// APIKeyPrefix starts every API key, so keys are easy to recognize (and to find in leaked code)
+const APIKeyPrefix = "mk_"
+
+// APIKey is a personal access key for scripts. Only the SHA-256 hash of the key is stored,
+// Prefix is kept in plain form to show and identify the key.
+type APIKey struct {
+	ID         int        `json:"id" db:"id"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	KeyHash    string     `json:"-" db:"key_hash"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// APIKeyOwner is an API key together with the auth state of its user
+type APIKeyOwner struct {
+	Key  APIKey
+	User UserAuthState
+}
+
+type CreateAPIKey struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// nil: the key does not expire
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreatedAPIKey is returned once, the plain key cannot be shown again
+type CreatedAPIKey struct {
+	Key    string `json:"api_key"`
+	APIKey APIKey `json:"details"`
+}
@@ -0,0 +1,90 @@
+package models
+
+import "time"
+
+// Audit event types
+const (
+	AuditUserRegistered        = "user.registered"
+	AuditUserLogin             = "user.login"
+	AuditUserLoginChallenge    = "user.login_2fa_challenge"
+	AuditUserMagicLinkSent     = "user.magic_link_requested"
+	AuditUserLogout            = "user.logout"
+	AuditUserLogoutAll         = "user.logout_all"
+	AuditRefreshTokenReused    = "user.refresh_token_reused"
+	AuditUserPasswordChanged   = "user.password_changed"
+	AuditUserPasswordResetSent = "user.password_reset_requested"
+	AuditUserPasswordReset     = "user.password_reset"
+	AuditUserEmailVerified     = "user.email_verified"
+	AuditUserEmailChangeSent   = "user.email_change_requested"
+	AuditUserEmailChanged      = "user.email_changed"
+	AuditUserEmailChangeCancel = "user.email_change_canceled"
+	AuditUserUpdated           = "user.updated"
+	AuditUserProfileUpdated    = "user.profile_updated"
+	AuditUserRoleChanged       = "user.role_changed"
+	AuditUserDeleted           = "user.deleted"
+	AuditUserErased            = "user.erased"
+	AuditUserDeactivated       = "user.deactivated"
+	AuditUserRestored          = "user.restored"
+	AuditUserDataExported      = "user.data_exported"
+	AuditUserUnlocked          = "user.unlocked"
+	AuditTwoFactorEnrolled     = "user.2fa_enrolled"
+	AuditTwoFactorEnabled      = "user.2fa_enabled"
+	AuditTwoFactorReset        = "user.2fa_reset"
+	AuditIdentityLinked        = "identity.linked"
+	AuditIdentityUnlinked      = "identity.unlinked"
+	AuditAPIKeyCreated         = "api_key.created"
+	AuditAPIKeyRevoked         = "api_key.revoked"
+	AuditProductCreated        = "product.created"
+	AuditProductUpdated        = "product.updated"
+	AuditProductDeleted        = "product.deleted"
+	AuditProductRestored       = "product.restored"
+	AuditProductsPurged        = "product.purged"
+	AuditAdminBootstrap        = "admin.bootstrap"
+	AuditUserImported          = "admin.user_imported"
+	AuditEventsExported        = "audit.exported"
+)
+
+// Audit event outcomes
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+	// the caller was not allowed to do it
+	AuditOutcomeDenied = "denied"
+)
+
+// Audit target types
+const (
+	AuditTargetUser    = "user"
+	AuditTargetProduct = "product"
+	AuditTargetAPIKey  = "api_key"
+)
+
+// AuditEvent is one row of the append-only security log
+type AuditEvent struct {
+	ID         int64     `json:"id" db:"id"`
+	OccurredAt time.Time `json:"occurred_at" db:"occurred_at"`
+	Type       string    `json:"type" db:"event_type"`
+	Outcome    string    `json:"outcome" db:"outcome"`
+	// who did it, nil for anonymous requests (e.g. a failed login) and the admin CLI
+	ActorID       *int `json:"actor_id" db:"actor_id"`
+	ActorAPIKeyID *int `json:"actor_api_key_id,omitempty" db:"actor_api_key_id"`
+	// what it was done to
+	TargetType string         `json:"target_type,omitempty" db:"target_type"`
+	TargetID   *int           `json:"target_id" db:"target_id"`
+	IP         string         `json:"ip" db:"ip"`
+	UserAgent  string         `json:"user_agent" db:"user_agent"`
+	Details    map[string]any `json:"details,omitempty" db:"details"`
+}
+
+// AuditEventFilter selects audit events, zero fields do not filter
+type AuditEventFilter struct {
+	// events where the user is the actor or the target
+	UserID  *int
+	Types   []string
+	Outcome string
+	From    *time.Time
+	To      *time.Time
+	// keyset pagination: events older than this ID
+	BeforeID *int64
+	Limit    int
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Identity links an account at an external OIDC provider (provider + subject) to a user
+type Identity struct {
+	ID       int    `json:"id" db:"id"`
+	UserID   int    `json:"user_id" db:"user_id"`
+	Provider string `json:"provider" db:"provider"`
+	// the provider's stable user ID ("sub" claim)
+	Subject     string     `json:"-" db:"subject"`
+	Email       string     `json:"email" db:"email"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at" db:"last_login_at"`
+}
+
+// OIDCLoginState is kept between the redirect to the provider and the callback.
+// Only the hashes of state and device token are stored; nonce and code verifier are needed
+// in plain form but are useless without the authorization code.
+type OIDCLoginState struct {
+	StateHash    string `db:"state_hash"`
+	Provider     string `db:"provider"`
+	Nonce        string `db:"nonce"`
+	CodeVerifier string `db:"code_verifier"`
+	// SHA-256 of the device token returned to the client that started the login
+	DeviceHash string `db:"device_hash"`
+	// set when a signed-in user links a provider, nil for a login
+	LinkUserID *int      `db:"link_user_id"`
+	ExpiresAt  time.Time `db:"expires_at"`
+}
+
+type StartOIDCLogin struct {
+	Provider string `json:"provider"`
+}
+
+// OIDCAuthorization is where the client sends the browser to sign in. The client keeps
+// DeviceToken and sends it back with the callback, so a callback URL passed on by someone
+// else cannot finish the login in this browser.
+type OIDCAuthorization struct {
+	AuthorizationURL string `json:"authorization_url"`
+	DeviceToken      string `json:"device_token"`
+}
+
+// OIDCCallback carries the query parameters the provider redirected back with
+// and the device token from OIDCAuthorization
+type OIDCCallback struct {
+	State       string `json:"state"`
+	Code        string `json:"code"`
+	DeviceToken string `json:"device_token"`
+}
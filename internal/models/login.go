@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Login is one successful sign-in of a user, kept as login history.
+// Every login starts its own session (refresh token family).
+// DeviceLabel and Network (the client's IP network) together identify the device.
+type Login struct {
+	ID          int       `json:"id" db:"id"`
+	UserID      int       `json:"user_id" db:"user_id"`
+	FamilyID    string    `json:"-" db:"family_id"`
+	IP          string    `json:"ip" db:"ip"`
+	UserAgent   string    `json:"user_agent" db:"user_agent"`
+	DeviceLabel string    `json:"device" db:"device_label"`
+	Network     string    `json:"-" db:"network"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// Session is a login whose refresh token is still valid
+type Session struct {
+	// ID of the login that started the session
+	ID          int       `json:"id"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	DeviceLabel string    `json:"device"`
+	SignedInAt  time.Time `json:"signed_in_at"`
+	// time of the last token refresh, the login time if there was none
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
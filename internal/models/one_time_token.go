@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Purposes of one-time tokens
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+	// issued after a correct password when the account has 2FA enabled
+	TokenPurposeTwoFactorChallenge = "two_factor_challenge"
+	// sent to the new address, the payload is the new email
+	TokenPurposeEmailChange = "email_change"
+	// sent to the old address, the payload is the old email
+	TokenPurposeEmailChangeCancel = "email_change_cancel"
+	// passwordless sign-in link, the payload is a MagicLinkBinding as JSON
+	TokenPurposeMagicLink = "magic_link"
+	// sent when an account is deleted, restores it until it is erased
+	TokenPurposeAccountRestore = "account_restore"
+)
+
+// OneTimeToken is a single-use token delivered by email.
+// Only the hash is stored, Payload holds optional purpose-specific data.
+type OneTimeToken struct {
+	ID        int       `json:"id" db:"id"`
+	UserID    int       `json:"user_id" db:"user_id"`
+	Purpose   string    `json:"purpose" db:"purpose"`
+	TokenHash string    `json:"-" db:"token_hash"`
+	Payload   string    `json:"-" db:"payload"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+}
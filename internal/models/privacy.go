@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// DataExport is everything stored about a user, returned by the "download my data" endpoint
+type DataExport struct {
+	ExportedAt       time.Time  `json:"exported_at"`
+	Profile          Profile    `json:"profile"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	Identities       []Identity `json:"linked_accounts"`
+	APIKeys          []APIKey   `json:"api_keys"`
+	Logins           []Login    `json:"logins"`
+	// including deleted products that are not purged yet
+	Products    []Product    `json:"products"`
+	AuditEvents []AuditEvent `json:"audit_events"`
+}
+
+// ErasureScheduled is the answer to DELETE /users/{id}: the account is soft deleted
+// and will be erased at ScheduledFor unless it is restored
+type ErasureScheduled struct {
+	Message      string    `json:"message"`
+	ScheduledFor time.Time `json:"erasure_scheduled_for"`
+}
+
+// RestoreAccount is the token of the restore link sent when an account is deleted
+type RestoreAccount struct {
+	Token string `json:"token"`
+}
@@ -8,13 +8,18 @@ type Product struct {
 	Title       string    `json:"title" db:"title"`
 	Description string    `json:"description" db:"description"`
 	Price       int       `json:"price" db:"price"`
+	SellerID    int       `json:"seller_id" db:"seller_id"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	// set only when the product was deleted, see ProductFilter.IncludeDeleted
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
 }
 
 type CreateProduct struct {
 	Title       string `json:"title"`
 	Description string `json:"description"`
 	Price       int    `json:"price"`
+	// set from the authenticated caller, never from the request body
+	SellerID int `json:"-"`
 }
 
 type UpdateProduct struct {
@@ -23,3 +28,10 @@ type UpdateProduct struct {
 	Description *string `json:"description"`
 	Price       *int    `json:"price"`
 }
+
+// ProductFilter narrows GetAllProducts, nil fields are not filtered on
+type ProductFilter struct {
+	SellerID *int
+	// deleted products are left out unless set (admins, data export)
+	IncludeDeleted bool
+}
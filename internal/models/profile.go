@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Profile is the caller's own account with every profile field (GET/PATCH /users/me).
+// Optional fields that are not set are empty strings.
+type Profile struct {
+	ID            int    `json:"id" db:"id"`
+	Email         string `json:"email" db:"email"`
+	EmailVerified bool   `json:"email_verified" db:"email_verified"`
+	Name          string `json:"name" db:"name"`
+	Role          string `json:"role" db:"role"`
+	DisplayName   string `json:"display_name" db:"display_name"`
+	Handle        string `json:"handle" db:"handle"`
+	Bio           string `json:"bio" db:"bio"`
+	AvatarURL     string `json:"avatar_url" db:"avatar_url"`
+	StatusText    string `json:"status_text" db:"status_text"`
+	StatusEmoji   string `json:"status_emoji" db:"status_emoji"`
+	// updated at most once a minute while the user makes signed-in requests
+	LastSeenAt *time.Time `json:"last_seen_at" db:"last_seen_at"`
+}
+
+// PublicProfile is what other users see. It never contains the email;
+// DisplayName falls back to the account name.
+type PublicProfile struct {
+	ID          int        `json:"id" db:"id"`
+	Handle      string     `json:"handle,omitempty" db:"handle"`
+	DisplayName string     `json:"display_name" db:"display_name"`
+	Bio         string     `json:"bio,omitempty" db:"bio"`
+	AvatarURL   string     `json:"avatar_url,omitempty" db:"avatar_url"`
+	StatusText  string     `json:"status_text,omitempty" db:"status_text"`
+	StatusEmoji string     `json:"status_emoji,omitempty" db:"status_emoji"`
+	LastSeenAt  *time.Time `json:"last_seen_at,omitempty" db:"last_seen_at"`
+}
+
+// UpdateProfile changes the profile fields that are sent (PATCH),
+// an empty string removes the field
+type UpdateProfile struct {
+	DisplayName *string `json:"display_name"`
+	Handle      *string `json:"handle"`
+	Bio         *string `json:"bio"`
+	AvatarURL   *string `json:"avatar_url"`
+	StatusText  *string `json:"status_text"`
+	StatusEmoji *string `json:"status_emoji"`
+}
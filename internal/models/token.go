@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// RefreshToken is a stored refresh token.
+// Tokens issued from one login share a FamilyID, every refresh rotates
+// the token inside that family.
+type RefreshToken struct {
+	ID        int       `json:"id" db:"id"`
+	UserID    int       `json:"user_id" db:"user_id"`
+	FamilyID  string    `json:"family_id" db:"family_id"`
+	TokenHash string    `json:"-" db:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// TwoFactorState is the TOTP configuration of a user as stored in users
+type TwoFactorState struct {
+	// encrypted with the server key, nil if 2FA was never enrolled
+	EncryptedSecret *string `db:"totp_secret"`
+	// false between enrollment and confirmation with a first code
+	Enabled bool `db:"totp_enabled"`
+	// last accepted time step, a code cannot be used twice
+	LastUsedStep *int64 `db:"totp_last_step"`
+}
+
+type EnrollTwoFactor struct {
+	Password string `json:"password"`
+}
+
+// TwoFactorEnrollment is shown once: the URI is rendered as a QR code by the client
+type TwoFactorEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type ConfirmTwoFactor struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes are shown once after 2FA is enabled, only hashes are stored
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// TwoFactorChallenge is returned by login instead of tokens when 2FA is enabled
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	ChallengeToken    string    `json:"challenge_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+// TwoFactorLogin finishes a login: either a TOTP code or a recovery code
+type TwoFactorLogin struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
@@ -1,22 +1,48 @@
 package models
 
+import "time"
+
+// User roles, stored in users.role
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type User struct {
 	// name for json
-	ID       int    `json:"id" db:"id"`
-	Email    string `json:"email" db:"email"`
-	Name     string `json:"name" db:"name"`
+	ID             int    `json:"id" db:"id"`
+	Email          string `json:"email" db:"email"`
+	Name           string `json:"name" db:"name"`
 	HashedPassword string `json:"password" db:"hashed_password"`
+	Role           string `json:"role" db:"role"`
+	EmailVerified  bool   `json:"email_verified" db:"email_verified"`
+	// login needs a second step (TOTP or recovery code)
+	TwoFactorEnabled bool `json:"two_factor_enabled" db:"two_factor_enabled"`
 }
 type UserWithoutPassword struct {
-	ID    int    `json:"id" db:"id"`
-	Email string `json:"email" db:"email"`
-	Name  string `json:"name" db:"name"`
+	ID            int    `json:"id" db:"id"`
+	Email         string `json:"email" db:"email"`
+	Name          string `json:"name" db:"name"`
+	Role          string `json:"role" db:"role"`
+	EmailVerified bool   `json:"email_verified" db:"email_verified"`
+	// only set for accounts that admins list with UserFilter.IncludeInactive
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty" db:"deactivated_at"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+}
+
+// UserFilter narrows GetAllUsers. Deactivated and deleted accounts are left out
+// unless IncludeInactive is set (admins restoring an account).
+type UserFilter struct {
+	IncludeInactive bool
 }
 
 type CreateUser struct {
 	Email    string `json:"email" db:"email"`
 	Name     string `json:"name" db:"name"`
 	Password string `json:"password" db:"hashed_password"`
+	// json:"-" — role can never be chosen by the client at registration
+	Role string `json:"-" db:"role"`
 }
 
 type UpdateUser struct {
@@ -26,7 +52,85 @@ type UpdateUser struct {
 	Password *string `json:"password" db:"hashed_password"`
 }
 
+type ChangePassword struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// UserAuthState is what the auth middleware needs to accept a token
+type UserAuthState struct {
+	ID            int    `db:"id"`
+	Role          string `db:"role"`
+	EmailVerified bool   `db:"email_verified"`
+	// tokens issued before this moment are rejected (password change, logout from all devices)
+	SessionsRevokedAt *time.Time `db:"sessions_revoked_at"`
+}
+
+type ForgotPassword struct {
+	Email string `json:"email"`
+}
+
+type ResetPassword struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// ChangeEmail starts an email change, the current password is required
+type ChangeEmail struct {
+	NewEmail string `json:"new_email"`
+	Password string `json:"password"`
+}
+
+// EmailChangeToken is the token of a confirmation or cancel link of an email change
+type EmailChangeToken struct {
+	Token string `json:"token"`
+}
+
+type VerifyEmail struct {
+	Token string `json:"token"`
+}
+
+type UpdateUserRole struct {
+	Role string `json:"role" db:"role"`
+}
+
 type AuthUser struct {
 	Email    string `json:"email" db:"email"`
 	Password string `json:"password" db:"hashed_password"`
 }
+
+// AuthResponse is returned after a successful login
+type AuthResponse struct {
+	AccessToken           string              `json:"access_token"`
+	TokenType             string              `json:"token_type"`
+	ExpiresAt             time.Time           `json:"expires_at"`
+	RefreshToken          string              `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time           `json:"refresh_token_expires_at"`
+	User                  UserWithoutPassword `json:"user"`
+}
+
+// RequestMagicLink asks for a sign-in link by email
+type RequestMagicLink struct {
+	Email string `json:"email"`
+}
+
+// MagicLinkRequested is the answer to every sign-in link request, whether the email is
+// registered or not. DeviceToken is set when links are bound to the requesting device:
+// the client keeps it and sends it back with the token from the link.
+type MagicLinkRequested struct {
+	Message     string `json:"message"`
+	DeviceToken string `json:"device_token,omitempty"`
+}
+
+// RedeemMagicLink exchanges the token from a sign-in link for a session
+type RedeemMagicLink struct {
+	Token       string `json:"token"`
+	DeviceToken string `json:"device_token,omitempty"`
+}
+
+// MagicLinkBinding is what a sign-in link is bound to, stored with the token
+type MagicLinkBinding struct {
+	IP string `json:"ip,omitempty"`
+	// SHA-256 of the device token returned to the requesting client
+	DeviceHash string `json:"device_hash,omitempty"`
+}
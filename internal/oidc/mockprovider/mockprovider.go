@@ -0,0 +1,284 @@
+// Package mockprovider is an in-process OpenID Connect provider for local development
+// and tests: discovery, JWKS, authorization endpoint (signs in without a login page)
+// and token endpoint with PKCE checks. Never use it in production.
+package mockprovider
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	keyID      = "mock-key"
+	codeTTL    = time.Minute
+	idTokenTTL = 5 * time.Minute
+)
+
+// User is who the provider signs in
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type pendingCode struct {
+	user          User
+	nonce         string
+	codeChallenge string
+	redirectURI   string
+	expiresAt     time.Time
+}
+
+// Server is a running mock provider, its issuer URL is URL
+type Server struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+
+	key    *rsa.PrivateKey
+	server *httptest.Server
+
+	mu    sync.Mutex
+	user  *User
+	codes map[string]pendingCode
+}
+
+// NewServer starts the provider on a random local port
+func NewServer(clientID string, clientSecret string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	mock := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		codes:        make(map[string]pendingCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", mock.discovery)
+	mux.HandleFunc("/jwks", mock.jwks)
+	mux.HandleFunc("/authorize", mock.authorize)
+	mux.HandleFunc("/token", mock.token)
+	mock.server = httptest.NewServer(mux)
+	mock.URL = mock.server.URL
+	return mock, nil
+}
+
+func (mock *Server) Close() {
+	mock.server.Close()
+}
+
+// SetUser makes /authorize sign in this user. Without it the user is derived
+// from the login_hint parameter (an email), so every email is a different account.
+func (mock *Server) SetUser(user User) {
+	mock.mu.Lock()
+	defer mock.mu.Unlock()
+	mock.user = &user
+}
+
+// Authorize opens an authorization URL like a browser would and returns the
+// redirect back to the app (with code and state in the query)
+func (mock *Server) Authorize(authCodeURL string) (*url.URL, error) {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	response, err := client.Get(authCodeURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusFound {
+		return nil, errors.New("authorization failed: " + response.Status)
+	}
+	return url.Parse(response.Header.Get("Location"))
+}
+
+func (mock *Server) discovery(response http.ResponseWriter, request *http.Request) {
+	writeJSON(response, http.StatusOK, map[string]any{
+		"issuer":                                mock.URL,
+		"authorization_endpoint":                mock.URL + "/authorize",
+		"token_endpoint":                        mock.URL + "/token",
+		"jwks_uri":                              mock.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (mock *Server) jwks(response http.ResponseWriter, request *http.Request) {
+	publicKey := mock.key.PublicKey
+	writeJSON(response, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}},
+	})
+}
+
+func (mock *Server) authorize(response http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	switch {
+	case query.Get("response_type") != "code":
+		http.Error(response, "unsupported response_type", http.StatusBadRequest)
+		return
+	case query.Get("client_id") != mock.ClientID:
+		http.Error(response, "unknown client_id", http.StatusBadRequest)
+		return
+	case redirectURI == "":
+		http.Error(response, "redirect_uri is required", http.StatusBadRequest)
+		return
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		http.Error(response, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := mock.userFor(query.Get("login_hint"))
+	if !ok {
+		http.Error(response, "no user: call SetUser or pass login_hint", http.StatusBadRequest)
+		return
+	}
+	code := randomString()
+	mock.mu.Lock()
+	mock.codes[code] = pendingCode{
+		user:          user,
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		redirectURI:   redirectURI,
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+	mock.mu.Unlock()
+
+	callback, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(response, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	callbackQuery := callback.Query()
+	callbackQuery.Set("code", code)
+	callbackQuery.Set("state", query.Get("state"))
+	callback.RawQuery = callbackQuery.Encode()
+	http.Redirect(response, request, callback.String(), http.StatusFound)
+}
+
+func (mock *Server) token(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := request.ParseForm(); err != nil {
+		tokenError(response, "invalid_request")
+		return
+	}
+	clientID, clientSecret, ok := request.BasicAuth()
+	if !ok {
+		clientID, clientSecret = request.PostForm.Get("client_id"), request.PostForm.Get("client_secret")
+	}
+	if clientID != mock.ClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(mock.ClientSecret)) != 1 {
+		tokenError(response, "invalid_client")
+		return
+	}
+	if request.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(response, "unsupported_grant_type")
+		return
+	}
+
+	// codes are single use
+	mock.mu.Lock()
+	pending, found := mock.codes[request.PostForm.Get("code")]
+	delete(mock.codes, request.PostForm.Get("code"))
+	mock.mu.Unlock()
+	if !found || time.Now().After(pending.expiresAt) || pending.redirectURI != request.PostForm.Get("redirect_uri") {
+		tokenError(response, "invalid_grant")
+		return
+	}
+	verifierHash := sha256.Sum256([]byte(request.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifierHash[:]) != pending.codeChallenge {
+		tokenError(response, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            mock.URL,
+		"sub":            pending.user.Subject,
+		"aud":            mock.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(idTokenTTL).Unix(),
+		"nonce":          pending.nonce,
+		"email":          pending.user.Email,
+		"email_verified": pending.user.EmailVerified,
+		"name":           pending.user.Name,
+	})
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(mock.key)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(response, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   int(idTokenTTL.Seconds()),
+		"id_token":     signed,
+	})
+}
+
+func (mock *Server) userFor(loginHint string) (User, bool) {
+	mock.mu.Lock()
+	defer mock.mu.Unlock()
+	if mock.user != nil {
+		return *mock.user, true
+	}
+	if loginHint == "" {
+		return User{}, false
+	}
+	name, _, _ := strings.Cut(loginHint, "@")
+	return User{
+		Subject:       "mock|" + strings.ToLower(loginHint),
+		Email:         loginHint,
+		EmailVerified: true,
+		Name:          name,
+	}, true
+}
+
+func randomString() string {
+	raw := make([]byte, 24)
+	rand.Read(raw)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func tokenError(response http.ResponseWriter, code string) {
+	writeJSON(response, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(response http.ResponseWriter, statusCode int, payload any) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(statusCode)
+	json.NewEncoder(response).Encode(payload)
+}
@@ -0,0 +1,134 @@
+// Package oidc signs users in with external OpenID Connect providers
+// (authorization code flow with PKCE, ID tokens verified against the provider JWKS)
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrMissingIDToken = errors.New("provider response has no id_token")
+	ErrNonceMismatch  = errors.New("id_token nonce does not match the login")
+)
+
+// ProviderConfig is the client registration at one provider
+type ProviderConfig struct {
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// callback page of the frontend, it posts state and code back to the API
+	RedirectURL string
+}
+
+// Claims are the ID token claims the app uses
+type Claims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+}
+
+// Provider talks to one OIDC provider
+type Provider struct {
+	name     string
+	oauth2   oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// NewProvider loads the provider metadata (issuer/.well-known/openid-configuration);
+// signing keys are fetched from its JWKS endpoint when a token is verified
+func NewProvider(ctx context.Context, config ProviderConfig) (*Provider, error) {
+	if config.IssuerURL == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("provider %s: issuer, client ID and redirect URL are required", config.Name)
+	}
+	discovered, err := gooidc.NewProvider(ctx, config.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %w", config.Name, err)
+	}
+	return &Provider{
+		name: config.Name,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     discovered.Endpoint(),
+			Scopes:       []string{gooidc.ScopeOpenID, "email", "profile"},
+		},
+		// checks signature, issuer, audience (our client ID) and expiry
+		verifier: discovered.Verifier(&gooidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+func (provider *Provider) Name() string {
+	return provider.name
+}
+
+// AuthCodeURL is where the browser is sent to sign in. The PKCE challenge is derived
+// from codeVerifier, which stays on the server until Exchange.
+func (provider *Provider) AuthCodeURL(state string, nonce string, codeVerifier string) string {
+	return provider.oauth2.AuthCodeURL(state,
+		gooidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(codeVerifier),
+	)
+}
+
+// GenerateCodeVerifier returns a new PKCE code verifier (RFC 7636)
+func GenerateCodeVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+// Exchange redeems the authorization code and returns the verified ID token claims.
+// The nonce must be the one sent in AuthCodeURL, so a token issued for another login is rejected.
+func (provider *Provider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*Claims, error) {
+	token, err := provider.oauth2.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+	idToken, err := provider.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	var claims Claims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrNonceMismatch
+	}
+	return &claims, nil
+}
+
+// ConfigsFromEnv reads OIDC_PROVIDERS=google,gitlab and for every name
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET, OIDC_<NAME>_REDIRECT_URL
+func ConfigsFromEnv() []ProviderConfig {
+	var configs []ProviderConfig
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		configs = append(configs, ProviderConfig{
+			Name:         name,
+			IssuerURL:    os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		})
+	}
+	return configs
+}
@@ -0,0 +1,128 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"lesson-proj/internal/oidc"
+	"lesson-proj/internal/oidc/mockprovider"
+	"net/url"
+	"testing"
+)
+
+const redirectURL = "http://localhost:3000/oidc/callback"
+
+func newMockProvider(t *testing.T) (*mockprovider.Server, *oidc.Provider) {
+	t.Helper()
+	mock, err := mockprovider.NewServer("test-client", "test-secret")
+	if err != nil {
+		t.Fatalf("start mock provider: %v", err)
+	}
+	t.Cleanup(mock.Close)
+	provider, err := oidc.NewProvider(context.Background(), oidc.ProviderConfig{
+		Name:         "mock",
+		IssuerURL:    mock.URL,
+		ClientID:     mock.ClientID,
+		ClientSecret: mock.ClientSecret,
+		RedirectURL:  redirectURL,
+	})
+	if err != nil {
+		t.Fatalf("configure provider: %v", err)
+	}
+	return mock, provider
+}
+
+// authorize signs in at the mock and returns the code from the redirect back to the app
+func authorize(t *testing.T, mock *mockprovider.Server, provider *oidc.Provider, state, nonce, codeVerifier string) string {
+	t.Helper()
+	callback, err := mock.Authorize(provider.AuthCodeURL(state, nonce, codeVerifier))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	if got := callback.Query().Get("state"); got != state {
+		t.Fatalf("callback state = %q, want %q", got, state)
+	}
+	return callback.Query().Get("code")
+}
+
+func TestExchangeReturnsClaims(t *testing.T) {
+	mock, provider := newMockProvider(t)
+	mock.SetUser(mockprovider.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true, Name: "Alice"})
+
+	codeVerifier := oidc.GenerateCodeVerifier()
+	code := authorize(t, mock, provider, "state-1", "nonce-1", codeVerifier)
+	claims, err := provider.Exchange(context.Background(), code, codeVerifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.Subject != "alice-1" || claims.Email != "alice@example.com" || !claims.EmailVerified || claims.Name != "Alice" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+}
+
+func TestExchangeReportsUnverifiedEmail(t *testing.T) {
+	mock, provider := newMockProvider(t)
+	mock.SetUser(mockprovider.User{Subject: "bob-1", Email: "bob@example.com", EmailVerified: false})
+
+	codeVerifier := oidc.GenerateCodeVerifier()
+	code := authorize(t, mock, provider, "state-1", "nonce-1", codeVerifier)
+	claims, err := provider.Exchange(context.Background(), code, codeVerifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.EmailVerified {
+		t.Fatal("email_verified = true for an unverified provider email")
+	}
+}
+
+func TestExchangeRejectsReplayedCallback(t *testing.T) {
+	mock, provider := newMockProvider(t)
+	mock.SetUser(mockprovider.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true})
+
+	codeVerifier := oidc.GenerateCodeVerifier()
+	code := authorize(t, mock, provider, "state-1", "nonce-1", codeVerifier)
+	if _, err := provider.Exchange(context.Background(), code, codeVerifier, "nonce-1"); err != nil {
+		t.Fatalf("first Exchange: %v", err)
+	}
+	if _, err := provider.Exchange(context.Background(), code, codeVerifier, "nonce-1"); err == nil {
+		t.Fatal("replayed code was accepted")
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	mock, provider := newMockProvider(t)
+	mock.SetUser(mockprovider.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true})
+
+	codeVerifier := oidc.GenerateCodeVerifier()
+	code := authorize(t, mock, provider, "state-1", "nonce-1", codeVerifier)
+	_, err := provider.Exchange(context.Background(), code, codeVerifier, "nonce-of-another-login")
+	if !errors.Is(err, oidc.ErrNonceMismatch) {
+		t.Fatalf("Exchange error = %v, want ErrNonceMismatch", err)
+	}
+}
+
+func TestExchangeRejectsWrongCodeVerifier(t *testing.T) {
+	mock, provider := newMockProvider(t)
+	mock.SetUser(mockprovider.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true})
+
+	code := authorize(t, mock, provider, "state-1", "nonce-1", oidc.GenerateCodeVerifier())
+	if _, err := provider.Exchange(context.Background(), code, oidc.GenerateCodeVerifier(), "nonce-1"); err == nil {
+		t.Fatal("code was redeemed with another PKCE verifier")
+	}
+}
+
+func TestAuthorizeRequiresPKCE(t *testing.T) {
+	mock, provider := newMockProvider(t)
+	mock.SetUser(mockprovider.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true})
+
+	authCodeURL, err := url.Parse(provider.AuthCodeURL("state-1", "nonce-1", oidc.GenerateCodeVerifier()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authCodeURL.Query()
+	query.Del("code_challenge")
+	query.Del("code_challenge_method")
+	authCodeURL.RawQuery = query.Encode()
+	if _, err := mock.Authorize(authCodeURL.String()); err == nil {
+		t.Fatal("authorization without a PKCE challenge was accepted")
+	}
+}
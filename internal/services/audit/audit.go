@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"lesson-proj/internal/authctx"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/models"
+	"lesson-proj/internal/services/permissions"
+	"log"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// AuditService writes security events and lets admins read them
+type AuditService struct {
+	repository *database.AuditEventRepository
+}
+
+func NewAuditService(repository *database.AuditEventRepository) *AuditService {
+	return &AuditService{
+		repository: repository,
+	}
+}
+
+// Record appends an event. The outcome follows err (nil: success, permission errors: denied,
+// anything else: failure) unless event.Outcome is set. The actor, IP and user agent
+// are taken from ctx when the event does not set them.
+// A failed write is logged, it never fails the operation being audited.
+// A nil *AuditService records nothing.
+func (service *AuditService) Record(ctx context.Context, event models.AuditEvent, err error) {
+	if service == nil {
+		return
+	}
+	if event.Outcome == "" {
+		event.Outcome = Outcome(err)
+	}
+	if err != nil && event.Outcome != models.AuditOutcomeSuccess {
+		if event.Details == nil {
+			event.Details = map[string]any{}
+		}
+		event.Details["error"] = err.Error()
+	}
+	if principal, ok := authctx.PrincipalFromContext(ctx); ok && event.ActorID == nil {
+		event.ActorID = &principal.UserID
+		if principal.IsAPIKey() {
+			event.ActorAPIKeyID = &principal.APIKeyID
+		}
+	}
+	client := authctx.ClientFromContext(ctx)
+	if event.IP == "" {
+		event.IP = client.IP
+	}
+	if event.UserAgent == "" {
+		event.UserAgent = client.UserAgent
+	}
+
+	// the request may already be canceled, the event must still be written
+	if err := service.repository.InsertEvent(context.WithoutCancel(ctx), event); err != nil {
+		log.Printf("failed to write audit event %s: %v", event.Type, err)
+	}
+}
+
+// Outcome classifies the result of an operation
+func Outcome(err error) string {
+	switch {
+	case err == nil:
+		return models.AuditOutcomeSuccess
+	case errors.Is(err, permissions.ErrUnauthenticated), errors.Is(err, permissions.ErrForbidden):
+		return models.AuditOutcomeDenied
+	default:
+		return models.AuditOutcomeFailure
+	}
+}
+
+// GetEvents returns one page of events, newest first, admins only
+func (service *AuditService) GetEvents(ctx context.Context, filter models.AuditEventFilter) ([]models.AuditEvent, error) {
+	if err := permissions.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultQueryLimit
+	}
+	if filter.Limit > maxQueryLimit {
+		filter.Limit = maxQueryLimit
+	}
+	return service.repository.GetEvents(ctx, filter)
+}
+
+// UserEvents returns every event where the user is the actor or the target, newest first.
+// It is the audit part of the user's data export: no permission check, the caller does it.
+// Events done by someone else (staff changing the account, anonymous login attempts) come
+// without IP, user agent and error, those belong to the other party.
+func (service *AuditService) UserEvents(ctx context.Context, userID int) ([]models.AuditEvent, error) {
+	events, err := service.repository.GetEvents(ctx, models.AuditEventFilter{UserID: &userID})
+	if err != nil {
+		return nil, err
+	}
+	for i := range events {
+		if events[i].ActorID != nil && *events[i].ActorID == userID {
+			continue
+		}
+		events[i].IP = ""
+		events[i].UserAgent = ""
+		delete(events[i].Details, "error")
+	}
+	return events, nil
+}
+
+// Export writes every matching event to w as JSON Lines (one event per line), admins only.
+// The export itself is audited.
+func (service *AuditService) Export(ctx context.Context, filter models.AuditEventFilter, w io.Writer) error {
+	if err := permissions.RequireAdmin(ctx); err != nil {
+		service.Record(ctx, models.AuditEvent{Type: models.AuditEventsExported}, err)
+		return err
+	}
+	filter.Limit = 0
+	encoder := json.NewEncoder(w)
+	count := 0
+	err := service.repository.ForEachEvent(ctx, filter, func(event models.AuditEvent) error {
+		count++
+		return encoder.Encode(event)
+	})
+	service.Record(ctx, models.AuditEvent{
+		Type:    models.AuditEventsExported,
+		Details: map[string]any{"events": count},
+	}, err)
+	return err
+}
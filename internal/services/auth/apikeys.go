@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"lesson-proj/internal/authctx"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/models"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"lesson-proj/internal/services/permissions"
+	"log"
+	"strings"
+	"time"
+)
+
+// active (not revoked, not expired) keys one user may have
+const maxAPIKeysPerUser = 20
+
+// CreateAPIKey issues a personal API key for the caller. The key is returned only here,
+// the database keeps its hash and the visible prefix.
+func (service *UserService) CreateAPIKey(ctx context.Context, input models.CreateAPIKey) (result *models.CreatedAPIKey, err error) {
+	defer func() {
+		event := models.AuditEvent{
+			Type:    models.AuditAPIKeyCreated,
+			Details: map[string]any{"name": input.Name, "scopes": input.Scopes},
+		}
+		if result != nil {
+			event.TargetType = models.AuditTargetAPIKey
+			event.TargetID = &result.APIKey.ID
+		}
+		service.audit.Record(ctx, event, err)
+	}()
+
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// a leaked key must not be able to create more keys
+	if caller.IsAPIKey() {
+		return nil, permissions.ErrForbidden
+	}
+	if err := authUtils.ValidateAPIKeyInput(input.Name, input.Scopes, input.ExpiresAt); err != nil {
+		return nil, err
+	}
+	count, err := service.apiKeys.CountActiveAPIKeys(ctx, caller.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxAPIKeysPerUser {
+		return nil, ErrTooManyAPIKeys
+	}
+
+	key, prefix, err := authUtils.GenerateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	created, err := service.apiKeys.CreateAPIKey(ctx, models.APIKey{
+		UserID:    caller.UserID,
+		Name:      strings.TrimSpace(input.Name),
+		Prefix:    prefix,
+		KeyHash:   authUtils.HashToken(key),
+		Scopes:    uniqueScopes(input.Scopes),
+		ExpiresAt: input.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.CreatedAPIKey{Key: key, APIKey: *created}, nil
+}
+
+// GetAPIKeys lists the caller's keys (without the keys themselves)
+func (service *UserService) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return service.apiKeys.GetAPIKeysByUserID(ctx, caller.UserID)
+}
+
+// RevokeAPIKey revokes one of the caller's keys, it stops working immediately
+func (service *UserService) RevokeAPIKey(ctx context.Context, id int) (err error) {
+	defer func() {
+		service.audit.Record(ctx, models.AuditEvent{
+			Type:       models.AuditAPIKeyRevoked,
+			TargetType: models.AuditTargetAPIKey,
+			TargetID:   &id,
+		}, err)
+	}()
+
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return err
+	}
+	return service.apiKeys.RevokeAPIKey(ctx, caller.UserID, id)
+}
+
+// AuthenticateAPIKey validates an API key and returns its owner limited to the key's scopes.
+// Like access tokens, role and email verification are read from the DB on every request.
+func (service *UserService) AuthenticateAPIKey(ctx context.Context, key string) (*authctx.Principal, error) {
+	prefix, ok := authUtils.ParseAPIKeyPrefix(key)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	owner, err := service.apiKeys.GetAPIKeyOwnerByPrefix(ctx, prefix)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(authUtils.HashToken(key)), []byte(owner.Key.KeyHash)) != 1 {
+		return nil, ErrInvalidToken
+	}
+	if owner.Key.ExpiresAt != nil && !owner.Key.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidToken
+	}
+
+	if err := service.apiKeys.TouchAPIKey(ctx, owner.Key.ID); err != nil {
+		log.Printf("failed to update last use of api key %d: %v", owner.Key.ID, err)
+	}
+	return &authctx.Principal{
+		UserID:        owner.User.ID,
+		Role:          owner.User.Role,
+		EmailVerified: owner.User.EmailVerified,
+		APIKeyID:      owner.Key.ID,
+		Scopes:        owner.Key.Scopes,
+	}, nil
+}
+
+func uniqueScopes(scopes []string) []string {
+	unique := make([]string, 0, len(scopes))
+	seen := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		if !seen[scope] {
+			seen[scope] = true
+			unique = append(unique, scope)
+		}
+	}
+	return unique
+}
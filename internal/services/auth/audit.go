@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"lesson-proj/internal/models"
+)
+
+// recordUserEvent audits an operation on a user account, targetID 0 when no account is known
+func (service *UserService) recordUserEvent(ctx context.Context, eventType string, targetID int, err error, details map[string]any) {
+	event := models.AuditEvent{
+		Type:    eventType,
+		Details: details,
+	}
+	if targetID != 0 {
+		event.TargetType = models.AuditTargetUser
+		event.TargetID = &targetID
+	}
+	service.audit.Record(ctx, event, err)
+}
+
+// recordLogin audits a login attempt with any method. A correct first factor on an account
+// with 2FA is its own event, the login is recorded when the second step finishes.
+func (service *UserService) recordLogin(ctx context.Context, method string, email string, userID int, err error) {
+	eventType := models.AuditUserLogin
+	outcome := ""
+	if errors.Is(err, ErrTwoFactorRequired) {
+		eventType = models.AuditUserLoginChallenge
+		outcome = models.AuditOutcomeSuccess
+	}
+	event := models.AuditEvent{
+		Type:    eventType,
+		Outcome: outcome,
+		Details: map[string]any{"method": method, "email": email},
+	}
+	if userID != 0 {
+		event.TargetType = models.AuditTargetUser
+		event.TargetID = &userID
+		// the request is anonymous, after a successful login the user is the actor
+		if err == nil {
+			event.ActorID = &userID
+		}
+	}
+	service.audit.Record(ctx, event, err)
+}
@@ -3,32 +3,128 @@ package services
 import (
 	"context"
 	"errors"
-	"fmt"
+	"lesson-proj/internal/authctx"
 	"lesson-proj/internal/database"
+	"lesson-proj/internal/mailer"
 	"lesson-proj/internal/models"
+	"lesson-proj/internal/oidc"
+	auditService "lesson-proj/internal/services/audit"
 	authUtils "lesson-proj/internal/services/auth/utils"
-	"os"
+	"lesson-proj/internal/services/permissions"
+	"log"
+	"strings"
+	"time"
 )
 
 type UserService struct {
-	repository *database.UserRepository
+	repository    *database.UserRepository
+	refreshTokens *database.RefreshTokenRepository
+	oneTimeTokens *database.OneTimeTokenRepository
+	recoveryCodes *database.RecoveryCodeRepository
+	identities    *database.IdentityRepository
+	apiKeys       *database.APIKeyRepository
+	logins        *database.LoginRepository
+	products      *database.ProductRepository
+	audit         *auditService.AuditService
+	tokens        *authUtils.TokenManager
+	mailer        mailer.Mailer
+	loginLimiter  *loginLimiter
+	// all Argon2 work goes through the pool to bound memory use
+	hashPool *authUtils.HashPool
+	// public URL of the app, used to build links in emails
+	appBaseURL string
+	// encrypts TOTP secrets, nil when 2FA is not configured
+	secretBox *authUtils.SecretBox
+	// shown in authenticator apps next to the account
+	totpIssuer string
+	// external OIDC providers by name
+	oidcProviders map[string]*oidc.Provider
+	magicLink     MagicLinkOptions
+	// how long an account waits between the erasure request and the erasure
+	erasureGracePeriod time.Duration
 }
 
-func NewUserService(repository *database.UserRepository) *UserService {
+// Dependencies groups everything UserService needs,
+// so the constructor does not grow a new parameter with every feature
+type Dependencies struct {
+	Users         *database.UserRepository
+	RefreshTokens *database.RefreshTokenRepository
+	OneTimeTokens *database.OneTimeTokenRepository
+	LoginAttempts *database.LoginAttemptRepository
+	RecoveryCodes *database.RecoveryCodeRepository
+	Identities    *database.IdentityRepository
+	APIKeys       *database.APIKeyRepository
+	Logins        *database.LoginRepository
+	Products      *database.ProductRepository
+	Audit         *auditService.AuditService
+	Tokens        *authUtils.TokenManager
+	HashPool      *authUtils.HashPool
+	Mailer        mailer.Mailer
+	AppBaseURL    string
+	// optional: without it users cannot enroll in 2FA
+	SecretBox     *authUtils.SecretBox
+	TOTPIssuer    string
+	OIDCProviders map[string]*oidc.Provider
+	MagicLink     MagicLinkOptions
+	// default 30 days
+	ErasureGracePeriod time.Duration
+}
+
+func NewUserService(deps Dependencies) *UserService {
+	erasureGracePeriod := deps.ErasureGracePeriod
+	if erasureGracePeriod <= 0 {
+		erasureGracePeriod = defaultErasureGracePeriod
+	}
 	return &UserService{
-		repository: repository,
+		repository:    deps.Users,
+		refreshTokens: deps.RefreshTokens,
+		oneTimeTokens: deps.OneTimeTokens,
+		recoveryCodes: deps.RecoveryCodes,
+		identities:    deps.Identities,
+		apiKeys:       deps.APIKeys,
+		logins:        deps.Logins,
+		products:      deps.Products,
+		audit:         deps.Audit,
+		tokens:        deps.Tokens,
+		mailer:        deps.Mailer,
+		loginLimiter:  newLoginLimiter(deps.LoginAttempts),
+		hashPool:      deps.HashPool,
+		appBaseURL:    strings.TrimSuffix(deps.AppBaseURL, "/"),
+		secretBox:     deps.SecretBox,
+		totpIssuer:    deps.TOTPIssuer,
+		oidcProviders: deps.OIDCProviders,
+		magicLink:     deps.MagicLink.withDefaults(),
+
+		erasureGracePeriod: erasureGracePeriod,
 	}
 }
 
-func (service *UserService) Registration(ctx context.Context, input models.CreateUser) (*models.UserWithoutPassword, error) {
+// Registration creates an account and emails a verification link.
+// If the email is already registered, the owner of the address gets a notice instead
+// and the caller sees the same result as for a new account, so registration
+// cannot be used to find out which emails have accounts.
+func (service *UserService) Registration(ctx context.Context, input models.CreateUser) (err error) {
+	var (
+		createdID int
+		duplicate bool
+	)
+	defer func() {
+		service.recordUserEvent(ctx, models.AuditUserRegistered, createdID, err, map[string]any{"email": input.Email, "duplicate": duplicate})
+	}()
+
 	if err := authUtils.ValidateCreateUserInput(input.Email, input.Name, input.Password); err != nil {
-		return nil, err
+		return err
+	}
+	// Alice@Example.com and alice@example.com are the same account
+	input.Email, err = authUtils.NormalizeEmail(input.Email)
+	if err != nil {
+		return err
 	}
 
-	
-	hashPassword, err := authUtils.HashPassword(input.Password, os.Getenv("PASSWORD_PEPPER"))
+	// hashing happens on both paths, so both take the same time
+	hashPassword, err := service.hashPool.Hash(ctx, input.Password)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	// avoid keeping plaintext longer than needed
 	input.Password = ""
@@ -37,51 +133,116 @@ func (service *UserService) Registration(ctx context.Context, input models.Creat
 		Email:    input.Email,
 		Name:     input.Name,
 		Password: hashPassword,
+		Role:     models.RoleUser,
 	})
+	if errors.Is(err, database.ErrAlreadyExists) {
+		duplicate = true
+		if err := service.sendAlreadyRegisteredEmail(ctx, input.Email); err != nil {
+			log.Printf("failed to send already-registered notice: %v", err)
+		}
+		return nil
+	}
 	if err != nil {
-		return nil, err
+		return err
 	}
+	createdID = createdUser.ID
 
-	return createdUser, nil
+	// the account exists even if the email could not be sent,
+	// the user can ask for a new link with ResendVerification
+	if err := service.sendVerificationEmail(ctx, *createdUser); err != nil {
+		log.Printf("failed to send verification email to user %d: %v", createdUser.ID, err)
+	}
+
+	return nil
 }
 
+// Authorization checks email + password and starts a new session.
+// If the account has 2FA enabled, no tokens are issued: a *TwoFactorRequiredError
+// carries a short-lived challenge to finish the login with CompleteTwoFactorLogin.
+// Unknown email and wrong password both return ErrInvalidCredentials after the same
+// Argon2 work, so neither the response nor its timing reveals registered emails.
+func (service *UserService) Authorization(ctx context.Context, email, password string) (response *models.AuthResponse, err error) {
+	var userID int
+	defer func() { service.recordLogin(ctx, "password", email, userID, err) }()
 
-func (service *UserService) Authorization(ctx context.Context, email, password string) (*models.UserWithoutPassword, error) {
-	user, err := service.repository.GetUserByEmail(ctx, email)
+	// an address that does not normalize cannot belong to an account and takes the unknown email path
+	if normalized, err := authUtils.NormalizeEmail(email); err == nil {
+		email = normalized
+	}
 
-	if err != nil {
+	// locked accounts/IPs are rejected before any DB lookup or Argon2 work
+	ip := authctx.ClientFromContext(ctx).IP
+	if err := service.loginLimiter.check(ctx, email, ip); err != nil {
 		return nil, err
 	}
-	pepper := os.Getenv("PASSWORD_PEPPER")
-	if pepper == "" {
-		return nil, errors.New("password pepper is not configured")
+	// no stored password is this long, refuse it without hashing
+	if authUtils.PasswordTooLong(password) {
+		service.loginLimiter.recordFailure(ctx, email, ip)
+		return nil, ErrInvalidCredentials
 	}
-	ok, err := authUtils.VerifyPassword(password, pepper, user.HashedPassword)
 
+	user, err := service.repository.GetUserByEmail(ctx, email)
+	if errors.Is(err, database.ErrNotFound) {
+		if err := service.hashPool.VerifyDummy(ctx, password); err != nil {
+			return nil, err
+		}
+		service.loginLimiter.recordFailure(ctx, email, ip)
+		return nil, ErrInvalidCredentials
+	}
 	if err != nil {
 		return nil, err
 	}
+	userID = user.ID
+	ok, err := service.hashPool.Verify(ctx, password, user.HashedPassword)
+	// overload and canceled requests are not a wrong password
+	if err != nil && (errors.Is(err, authUtils.ErrHashPoolFull) || ctx.Err() != nil) {
+		return nil, err
+	}
+	if err != nil {
+		// a broken stored hash must look like a wrong password from outside
+		log.Printf("failed to verify password of user %d: %v", user.ID, err)
+		ok = false
+	}
 	if !ok {
-		return nil, fmt.Errorf("invalid password")
+		service.loginLimiter.recordFailure(ctx, email, ip)
+		return nil, ErrInvalidCredentials
 	}
-	userWithoutPassword := &models.UserWithoutPassword{
-		ID:    user.ID,
-		Email: user.Email,
-		Name:  user.Name,
+	// a successful login clears the account counter (not the IP one: a single valid
+	// account must not let an attacker reset the limit for guessing others)
+	if err := service.loginLimiter.reset(ctx, models.LoginScopeAccount, accountKey(email)); err != nil {
+		log.Printf("failed to reset login attempts of user %d: %v", user.ID, err)
 	}
-	return userWithoutPassword, nil
-	
+	// the password is known to be correct only now: upgrade an outdated hash
+	service.rehashIfNeeded(ctx, user, password)
+	return service.completeLogin(ctx, user)
 }
 
-func (service *UserService) GetAllUsers(ctx context.Context) ([]models.UserWithoutPassword, error) {
-	users, err := service.repository.GetAllUsers(ctx)
+// HashPoolStats returns the password hashing pool counters, admins only
+func (service *UserService) HashPoolStats(ctx context.Context) (*authUtils.HashPoolStats, error) {
+	if err := permissions.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	stats := service.hashPool.Stats()
+	return &stats, nil
+}
+
+// GetAllUsers is available to admins only
+func (service *UserService) GetAllUsers(ctx context.Context, filter models.UserFilter) ([]models.UserWithoutPassword, error) {
+	if err := permissions.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	users, err := service.repository.GetAllUsers(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
 	return users, nil
 }
 
+// GetUserByID returns the caller's own account, moderators and admins can read any account
 func (service *UserService) GetUserByID(ctx context.Context, id int) (*models.UserWithoutPassword, error) {
+	if err := permissions.RequireOwnerOrStaff(ctx, id); err != nil {
+		return nil, err
+	}
 	user, err := service.repository.GetUserByID(ctx, id)
 	if err != nil {
 		return nil, err
@@ -89,17 +250,106 @@ func (service *UserService) GetUserByID(ctx context.Context, id int) (*models.Us
 	return user, nil
 }
 
-func (service *UserService) UpdateUser(ctx context.Context, id int, input models.UpdateUser) (*models.UserWithoutPassword, error) {
-	if err := authUtils.ValidateUpdateUserInput(input.Email, input.Name, input.Password); err != nil {
+// UpdateUser updates profile fields. A password or email sent here is only accepted from
+// an admin changing another account; users change their own password with ChangePassword
+// and their email with RequestEmailChange, which check the current password.
+// An email set by an admin has to be verified again: the old address gets a notice,
+// the new one a verification link, and links sent to the old address stop working.
+func (service *UserService) UpdateUser(ctx context.Context, id int, input models.UpdateUser) (updatedUser *models.UserWithoutPassword, err error) {
+	defer func() {
+		service.recordUserEvent(ctx, models.AuditUserUpdated, id, err, map[string]any{
+			"email_changed":    input.Email != nil,
+			"name_changed":     input.Name != nil,
+			"password_changed": input.Password != nil,
+		})
+	}()
+
+	if err := permissions.RequireOwnerOrAdmin(ctx, id); err != nil {
 		return nil, err
 	}
-	updatedUser, err := service.repository.UpdateUser(ctx, id, input)
+	if err := authUtils.ValidateUpdateUserInput(input.Email, input.Name); err != nil {
+		return nil, err
+	}
+	if input.Email != nil {
+		caller, _ := permissions.Caller(ctx)
+		if caller.UserID == id || caller.Role != models.RoleAdmin {
+			return nil, ErrUseEmailChange
+		}
+		normalized, err := authUtils.NormalizeEmail(*input.Email)
+		if err != nil {
+			return nil, err
+		}
+		input.Email = &normalized
+	}
+	if input.Password != nil {
+		caller, _ := permissions.Caller(ctx)
+		if caller.UserID == id || caller.Role != models.RoleAdmin {
+			return nil, ErrUseChangePassword
+		}
+		user, err := service.repository.GetUserByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		email, name := user.Email, user.Name
+		if input.Email != nil {
+			email = *input.Email
+		}
+		if input.Name != nil {
+			name = *input.Name
+		}
+		if err := authUtils.ValidateNewPassword("password", *input.Password, email, name); err != nil {
+			return nil, err
+		}
+		hashedPassword, err := service.hashPool.Hash(ctx, *input.Password)
+		if err != nil {
+			return nil, err
+		}
+		input.Password = &hashedPassword
+	}
+	// the password is stored together with the other fields, so a failed update changes nothing
+	updatedUser, previousEmail, err := service.repository.UpdateUser(ctx, id, input)
 	if err != nil {
 		return nil, err
 	}
+	if input.Password != nil {
+		if err := service.refreshTokens.RevokeAllForUser(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	if updatedUser.Email != previousEmail {
+		mailCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailSendTimeout)
+		go func() {
+			defer cancel()
+			if err := service.sendEmailReplacedNotice(mailCtx, *updatedUser, previousEmail); err != nil {
+				log.Printf("failed to send email change notice to user %d: %v", updatedUser.ID, err)
+			}
+			if err := service.sendVerificationEmail(mailCtx, *updatedUser); err != nil {
+				log.Printf("failed to send verification email to user %d: %v", updatedUser.ID, err)
+			}
+		}()
+	}
 	return updatedUser, nil
 }
 
-func (service *UserService) DeleteUser(ctx context.Context, id int) error {
-	return service.repository.DeleteUser(ctx, id)
+// UpdateUserRole changes the role of a user, admins only
+func (service *UserService) UpdateUserRole(ctx context.Context, id int, role string) (updatedUser *models.UserWithoutPassword, err error) {
+	defer func() {
+		service.recordUserEvent(ctx, models.AuditUserRoleChanged, id, err, map[string]any{"role": role})
+	}()
+
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := permissions.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := authUtils.ValidateRole(role); err != nil {
+		return nil, &authUtils.ValidationError{Fields: map[string]string{"role": err.Error()}}
+	}
+	// an admin demoting themselves could leave the system without any admin
+	if caller.UserID == id && role != models.RoleAdmin {
+		return nil, ErrCannotDemoteSelf
+	}
+	return service.repository.UpdateUserRole(ctx, id, role)
 }
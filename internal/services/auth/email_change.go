@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/mailer"
+	"lesson-proj/internal/models"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"lesson-proj/internal/services/permissions"
+	"log"
+	"net/url"
+	"time"
+)
+
+const (
+	emailChangeTTL = 24 * time.Hour
+	// the old address can undo a change for longer than the new one can confirm it,
+	// so a hijacked account can be recovered after the attacker confirmed their address
+	emailChangeCancelTTL = 7 * 24 * time.Hour
+)
+
+// RequestEmailChange starts changing the caller's email. The address does not change yet:
+// the new address gets a confirmation link, the current one a notice with a cancel link.
+// If the new address belongs to another account, its owner is notified instead. The lookup
+// and the mails run in the background, so the caller sees the same result in the same time
+// and the flow cannot be used to find registered emails.
+func (service *UserService) RequestEmailChange(ctx context.Context, input models.ChangeEmail) (err error) {
+	var userID int
+	defer func() {
+		service.recordUserEvent(ctx, models.AuditUserEmailChangeSent, userID, err, map[string]any{"new_email": input.NewEmail})
+	}()
+
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return err
+	}
+	userID = caller.UserID
+	if err := authUtils.ValidateEmail("new_email", input.NewEmail); err != nil {
+		return err
+	}
+	newEmail, err := authUtils.NormalizeEmail(input.NewEmail)
+	if err != nil {
+		return err
+	}
+
+	user, err := service.repository.GetUserWithPasswordByID(ctx, caller.UserID)
+	if err != nil {
+		return err
+	}
+	if err := service.verifyCurrentPassword(ctx, user, input.Password); err != nil {
+		return err
+	}
+	if newEmail == user.Email {
+		return &authUtils.ValidationError{Fields: map[string]string{"new_email": "this is already your email"}}
+	}
+
+	// the lookup, the token writes and the mails run in the background,
+	// so a registered address does not answer slower or faster than a free one
+	mailCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailSendTimeout)
+	go func() {
+		defer cancel()
+		if err := service.sendEmailChange(mailCtx, user, newEmail); err != nil {
+			log.Printf("failed to send email change for user %d: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+// sendEmailChange emails the confirmation link to the new address and the cancel link to the
+// current one, or only notifies the owner when the new address belongs to another account
+func (service *UserService) sendEmailChange(ctx context.Context, user *models.User, newEmail string) error {
+	taken, err := service.repository.EmailTaken(ctx, newEmail)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return service.sendEmailInUseNotice(ctx, newEmail)
+	}
+
+	// only the latest request can be confirmed, cancel links of earlier ones keep working
+	if err := service.oneTimeTokens.InvalidateUserTokens(ctx, user.ID, models.TokenPurposeEmailChange); err != nil {
+		return err
+	}
+	confirmToken, err := service.issueOneTimeToken(ctx, user.ID, models.TokenPurposeEmailChange, newEmail, emailChangeTTL)
+	if err != nil {
+		return err
+	}
+	cancelToken, err := service.issueOneTimeToken(ctx, user.ID, models.TokenPurposeEmailChangeCancel, user.Email, emailChangeCancelTTL)
+	if err != nil {
+		return err
+	}
+
+	err = service.mailer.Send(ctx, mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nopen this link to use this address for your account:\n%s\n\n"+
+				"The link is valid for 24 hours. If you did not ask for this, ignore this email.",
+			user.Name, service.appBaseURL+"/confirm-email-change?token="+url.QueryEscape(confirmToken),
+		),
+	})
+	if err != nil {
+		return err
+	}
+	return service.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your email is about to change",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nsomeone asked to change the email of your account to %s.\n"+
+				"If it was not you, open this link to cancel the change and sign out all devices:\n%s\n\n"+
+				"The link works for 7 days, also after the new address was confirmed. Then reset your password.",
+			user.Name, newEmail, service.appBaseURL+"/cancel-email-change?token="+url.QueryEscape(cancelToken),
+		),
+	})
+}
+
+// ConfirmEmailChange redeems the link sent to the new address and swaps the email.
+// The new address counts as verified. ErrEmailTaken if it was registered in the meantime.
+func (service *UserService) ConfirmEmailChange(ctx context.Context, token string) (err error) {
+	var userID int
+	defer func() { service.recordUserEvent(ctx, models.AuditUserEmailChanged, userID, err, nil) }()
+
+	if token == "" {
+		return ErrInvalidToken
+	}
+	confirmed, err := service.repository.ConfirmEmailChange(ctx, authUtils.HashToken(token))
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrInvalidToken
+	}
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+	userID = confirmed.UserID
+	return nil
+}
+
+// CancelEmailChange redeems the link sent to the old address. Pending changes stop working;
+// a change that was already confirmed is undone and every session is revoked,
+// since whoever confirmed it may have taken over the account.
+func (service *UserService) CancelEmailChange(ctx context.Context, token string) (err error) {
+	var (
+		userID   int
+		reverted bool
+	)
+	defer func() {
+		service.recordUserEvent(ctx, models.AuditUserEmailChangeCancel, userID, err, map[string]any{"reverted": reverted})
+	}()
+
+	if token == "" {
+		return ErrInvalidToken
+	}
+	canceled, reverted, err := service.repository.CancelEmailChange(ctx, authUtils.HashToken(token))
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrInvalidToken
+	}
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+	userID = canceled.UserID
+	if !reverted {
+		return nil
+	}
+	return service.revokeAllSessions(ctx, canceled.UserID)
+}
+
+// sendEmailReplacedNotice tells the previous address that an admin moved the account to another one
+func (service *UserService) sendEmailReplacedNotice(ctx context.Context, user models.UserWithoutPassword, previousEmail string) error {
+	return service.mailer.Send(ctx, mailer.Message{
+		To:      previousEmail,
+		Subject: "Your email was changed",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nan administrator changed the email of your account to %s.\n"+
+				"Links sent to this address before no longer work. If you did not ask for this, contact support.",
+			user.Name, user.Email,
+		),
+	})
+}
+
+// sendEmailInUseNotice tells the owner of an address that someone tried
+// to move another account to it
+func (service *UserService) sendEmailInUseNotice(ctx context.Context, email string) error {
+	return service.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Someone tried to use your email",
+		Body: "Someone asked to change the email of another account to this address, but it already belongs to your account.\n\n" +
+			"Nothing was changed. If it was you, sign in to the account that uses this address.",
+	})
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"lesson-proj/internal/models"
+	"time"
+)
+
+// Errors returned by UserService that handlers map to HTTP status codes
+var (
+	ErrInvalidCredentials   = errors.New("invalid email or password")
+	ErrInvalidToken         = errors.New("invalid or expired token")
+	ErrRefreshTokenReused   = errors.New("refresh token was already used, all sessions of this login were revoked")
+	ErrUseChangePassword    = errors.New("use POST /users/{id}/password to change your password")
+	ErrInvalidPassword      = errors.New("current password is incorrect")
+	ErrEmailAlreadyVerified = errors.New("email is already verified")
+	ErrUseEmailChange       = errors.New("use POST /users/email/change to change your email")
+	ErrEmailTaken           = errors.New("this email is already used by another account")
+
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("start two-factor enrollment first")
+	ErrTwoFactorUnavailable    = errors.New("two-factor authentication is not configured on this server")
+
+	ErrUnknownProvider       = errors.New("unknown identity provider")
+	ErrExternalLoginFailed   = errors.New("external login failed")
+	ErrExternalEmailRequired = errors.New("the identity provider did not confirm an email address")
+	ErrAccountExists         = errors.New("an account with this email already exists, sign in with your password and link the provider")
+	ErrIdentityAlreadyLinked = errors.New("this external account is already linked to another user")
+
+	ErrTooManyAPIKeys = errors.New("too many active API keys, revoke one first")
+
+	ErrMagicLinkWrongDevice = errors.New("open the sign-in link on the device that requested it")
+
+	ErrCannotDeactivateSelf = errors.New("admins cannot deactivate their own account")
+	ErrCannotDemoteSelf     = errors.New("admins cannot remove their own admin role")
+
+	ErrHandleTaken = errors.New("this handle is already taken")
+)
+
+// ErrTooManyAttempts is matched by TooManyAttemptsError with errors.Is
+var ErrTooManyAttempts = errors.New("too many failed login attempts")
+
+// TooManyAttemptsError is returned while an account or IP is locked out
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (err *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("%s, try again in %s", ErrTooManyAttempts, err.RetryAfter.Round(time.Second))
+}
+
+func (err *TooManyAttemptsError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// ErrTwoFactorRequired is matched by TwoFactorRequiredError with errors.Is
+var ErrTwoFactorRequired = errors.New("two-factor authentication required")
+
+// TwoFactorRequiredError is returned by Authorization when the password was correct
+// but the account needs a second factor; Challenge is exchanged for tokens
+// with CompleteTwoFactorLogin
+type TwoFactorRequiredError struct {
+	Challenge models.TwoFactorChallenge
+}
+
+func (err *TwoFactorRequiredError) Error() string {
+	return ErrTwoFactorRequired.Error()
+}
+
+func (err *TwoFactorRequiredError) Is(target error) bool {
+	return target == ErrTwoFactorRequired
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/models"
+	"lesson-proj/internal/services/permissions"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lockoutPolicy describes when a scope gets locked and for how long:
+// after threshold failures the lock lasts baseLock, doubling with every
+// further failure up to maxLock. Counters reset after window without failures.
+type lockoutPolicy struct {
+	threshold int
+	baseLock  time.Duration
+	maxLock   time.Duration
+	window    time.Duration
+}
+
+var lockoutPolicies = map[string]lockoutPolicy{
+	// one account: few attempts, a real user rarely mistypes 5 times in a row
+	models.LoginScopeAccount: {threshold: 5, baseLock: time.Minute, maxLock: time.Hour, window: 24 * time.Hour},
+	// one IP: many users may share it (NAT, office), so the threshold is higher
+	models.LoginScopeIP: {threshold: 20, baseLock: time.Minute, maxLock: time.Hour, window: time.Hour},
+}
+
+// maxCachedLocks bounds the in-memory cache, expired entries are pruned above it
+const maxCachedLocks = 10000
+
+// loginLimiter tracks failed logins per account and per IP.
+// Postgres is the source of truth (survives restarts), active locks are also kept
+// in memory so a locked client is rejected without a DB query or an Argon2 hash.
+type loginLimiter struct {
+	repository *database.LoginAttemptRepository
+
+	mutex sync.Mutex
+	// "scope:key" -> locked until
+	locks map[string]time.Time
+}
+
+func newLoginLimiter(repository *database.LoginAttemptRepository) *loginLimiter {
+	return &loginLimiter{
+		repository: repository,
+		locks:      make(map[string]time.Time),
+	}
+}
+
+// accountKey normalizes the email so "Alice@x.com " and "alice@x.com" share a counter
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// check returns a *TooManyAttemptsError when the account or the IP is locked
+func (limiter *loginLimiter) check(ctx context.Context, email string, ip string) error {
+	for _, scopeKey := range limiter.scopeKeys(email, ip) {
+		lockedUntil, err := limiter.lockedUntil(ctx, scopeKey[0], scopeKey[1])
+		if err != nil {
+			return err
+		}
+		if wait := time.Until(lockedUntil); wait > 0 {
+			return &TooManyAttemptsError{RetryAfter: wait}
+		}
+	}
+	return nil
+}
+
+// recordFailure counts a failed login for the account and the IP and locks them
+// when the policy says so. Errors are only logged: the login is rejected anyway.
+func (limiter *loginLimiter) recordFailure(ctx context.Context, email string, ip string) {
+	for _, scopeKey := range limiter.scopeKeys(email, ip) {
+		scope, key := scopeKey[0], scopeKey[1]
+		policy := lockoutPolicies[scope]
+
+		attempt, err := limiter.repository.RecordFailure(ctx, scope, key, policy.window)
+		if err != nil {
+			log.Printf("failed to record login failure for %s: %v", scope, err)
+			continue
+		}
+		if attempt.Failures < policy.threshold {
+			continue
+		}
+
+		// exponential backoff: base, 2*base, 4*base, ... up to maxLock
+		lock := policy.baseLock
+		for i := policy.threshold; i < attempt.Failures && lock < policy.maxLock; i++ {
+			lock *= 2
+		}
+		if lock > policy.maxLock {
+			lock = policy.maxLock
+		}
+		lockedUntil := time.Now().Add(lock)
+		if err := limiter.repository.LockUntil(ctx, scope, key, lockedUntil); err != nil {
+			log.Printf("failed to lock %s after login failures: %v", scope, err)
+			continue
+		}
+		limiter.cacheLock(scope, key, lockedUntil)
+	}
+}
+
+// reset clears the counter of one scope (successful login, admin unlock)
+func (limiter *loginLimiter) reset(ctx context.Context, scope string, key string) error {
+	limiter.mutex.Lock()
+	delete(limiter.locks, scope+":"+key)
+	limiter.mutex.Unlock()
+	return limiter.repository.ResetLoginAttempts(ctx, scope, key)
+}
+
+func (limiter *loginLimiter) scopeKeys(email string, ip string) [][2]string {
+	keys := [][2]string{{models.LoginScopeAccount, accountKey(email)}}
+	if ip != "" {
+		keys = append(keys, [2]string{models.LoginScopeIP, ip})
+	}
+	return keys
+}
+
+func (limiter *loginLimiter) lockedUntil(ctx context.Context, scope string, key string) (time.Time, error) {
+	// fast path: an active lock in memory
+	limiter.mutex.Lock()
+	cached, ok := limiter.locks[scope+":"+key]
+	if ok && time.Now().After(cached) {
+		delete(limiter.locks, scope+":"+key)
+		ok = false
+	}
+	limiter.mutex.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	attempt, err := limiter.repository.GetLoginAttempt(ctx, scope, key)
+	if errors.Is(err, database.ErrNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if attempt.LockedUntil == nil {
+		return time.Time{}, nil
+	}
+	limiter.cacheLock(scope, key, *attempt.LockedUntil)
+	return *attempt.LockedUntil, nil
+}
+
+func (limiter *loginLimiter) cacheLock(scope string, key string, lockedUntil time.Time) {
+	if time.Now().After(lockedUntil) {
+		return
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if len(limiter.locks) >= maxCachedLocks {
+		now := time.Now()
+		for cachedKey, until := range limiter.locks {
+			if now.After(until) {
+				delete(limiter.locks, cachedKey)
+			}
+		}
+	}
+	limiter.locks[scope+":"+key] = lockedUntil
+}
+
+// UnlockAccount clears failed logins and the lockout of a user, admins only
+func (service *UserService) UnlockAccount(ctx context.Context, id int) (err error) {
+	defer func() { service.recordUserEvent(ctx, models.AuditUserUnlocked, id, err, nil) }()
+
+	if err := permissions.RequireAdmin(ctx); err != nil {
+		return err
+	}
+	user, err := service.repository.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	return service.loginLimiter.reset(ctx, models.LoginScopeAccount, accountKey(user.Email))
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lesson-proj/internal/authctx"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/mailer"
+	"lesson-proj/internal/models"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"log"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultMagicLinkTTL = 15 * time.Minute
+	// at most this many links per account and window, further requests send nothing
+	magicLinkRequestLimit  = 3
+	magicLinkRequestWindow = 15 * time.Minute
+)
+
+// MagicLinkOptions configure passwordless sign-in links
+type MagicLinkOptions struct {
+	// how long a link works, default 15 minutes
+	TTL time.Duration
+	// the link only works from the IP address that requested it
+	BindIP bool
+	// the link only works together with the device token returned to the requesting client,
+	// so a forwarded or intercepted link alone cannot sign in
+	BindDevice bool
+}
+
+func (options MagicLinkOptions) withDefaults() MagicLinkOptions {
+	if options.TTL <= 0 {
+		options.TTL = defaultMagicLinkTTL
+	}
+	return options
+}
+
+// RequestMagicLink emails a single-use sign-in link if an account with this email exists.
+// Like ForgotPassword, the answer and its timing are the same for unknown emails, and
+// requests over the per-account limit are silently dropped for the same reason.
+func (service *UserService) RequestMagicLink(ctx context.Context, email string) (requested *models.MagicLinkRequested, err error) {
+	var userID int
+	defer func() {
+		service.recordUserEvent(ctx, models.AuditUserMagicLinkSent, userID, err, map[string]any{"email": email})
+	}()
+
+	requested = &models.MagicLinkRequested{
+		Message: "If an account with this email exists, a sign-in link has been sent",
+	}
+	binding := models.MagicLinkBinding{}
+	if service.magicLink.BindIP {
+		binding.IP = authctx.ClientFromContext(ctx).IP
+	}
+	if service.magicLink.BindDevice {
+		deviceToken, err := authUtils.GenerateRandomToken()
+		if err != nil {
+			return nil, err
+		}
+		requested.DeviceToken = deviceToken
+		binding.DeviceHash = authUtils.HashToken(deviceToken)
+	}
+
+	if normalized, err := authUtils.NormalizeEmail(email); err == nil {
+		email = normalized
+	}
+	user, err := service.repository.GetUserByEmail(ctx, email)
+	if errors.Is(err, database.ErrNotFound) {
+		return requested, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	userID = user.ID
+
+	// the request may finish before the mail is sent, keep the context values but not its cancellation
+	mailCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailSendTimeout)
+	go func() {
+		defer cancel()
+		if err := service.sendMagicLink(mailCtx, user, binding); err != nil {
+			log.Printf("failed to send sign-in link to user %d: %v", user.ID, err)
+		}
+	}()
+	return requested, nil
+}
+
+// RedeemMagicLink signs in with the token from a sign-in link and returns the same
+// credentials as Authorization, including the 2FA challenge for accounts with 2FA.
+// Opening the link proves the user owns the address, so the email becomes verified.
+func (service *UserService) RedeemMagicLink(ctx context.Context, input models.RedeemMagicLink) (response *models.AuthResponse, err error) {
+	var (
+		userID int
+		email  string
+	)
+	defer func() { service.recordLogin(ctx, "magic_link", email, userID, err) }()
+
+	if input.Token == "" {
+		return nil, ErrInvalidToken
+	}
+	tokenHash := authUtils.HashToken(input.Token)
+	// checked before it is consumed: a link tried from the wrong device still works on the right one
+	pending, err := service.oneTimeTokens.GetValidToken(ctx, models.TokenPurposeMagicLink, tokenHash)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	user, err := service.repository.GetUserWithPasswordByID(ctx, pending.UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	userID, email = user.ID, user.Email
+
+	ip := authctx.ClientFromContext(ctx).IP
+	if err := service.loginLimiter.check(ctx, user.Email, ip); err != nil {
+		return nil, err
+	}
+	var binding models.MagicLinkBinding
+	if err := json.Unmarshal([]byte(pending.Payload), &binding); err != nil {
+		return nil, err
+	}
+	if !bindingMatches(binding, ip, input.DeviceToken) {
+		service.loginLimiter.recordFailure(ctx, user.Email, ip)
+		return nil, ErrMagicLinkWrongDevice
+	}
+
+	// single use, even when redeemed twice in parallel
+	if _, err := service.oneTimeTokens.ConsumeToken(ctx, models.TokenPurposeMagicLink, tokenHash); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if !user.EmailVerified {
+		if err := service.repository.MarkEmailVerified(ctx, user.ID); err != nil {
+			return nil, err
+		}
+		user.EmailVerified = true
+	}
+	if err := service.loginLimiter.reset(ctx, models.LoginScopeAccount, accountKey(user.Email)); err != nil {
+		log.Printf("failed to reset login attempts of user %d: %v", user.ID, err)
+	}
+	return service.completeLogin(ctx, user)
+}
+
+func (service *UserService) sendMagicLink(ctx context.Context, user *models.User, binding models.MagicLinkBinding) error {
+	recent, err := service.oneTimeTokens.CountTokensSince(ctx, user.ID, models.TokenPurposeMagicLink, time.Now().Add(-magicLinkRequestWindow))
+	if err != nil {
+		return err
+	}
+	if recent >= magicLinkRequestLimit {
+		log.Printf("sign-in link for user %d not sent: %d links in the last %s", user.ID, recent, magicLinkRequestWindow)
+		return nil
+	}
+
+	payload, err := json.Marshal(binding)
+	if err != nil {
+		return err
+	}
+	token, err := service.issueOneTimeToken(ctx, user.ID, models.TokenPurposeMagicLink, string(payload), service.magicLink.TTL)
+	if err != nil {
+		return err
+	}
+	link := service.appBaseURL + "/magic-link?token=" + url.QueryEscape(token)
+	return service.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nopen this link to sign in:\n%s\n\n"+
+				"The link is valid for %d minutes and works once. If you did not ask for it, ignore this email.",
+			user.Name, link, max(1, int(service.magicLink.TTL.Minutes())),
+		),
+	})
+}
+
+// bindingMatches compares the redeeming client with the one that requested the link,
+// empty binding fields are not checked
+func bindingMatches(binding models.MagicLinkBinding, ip string, deviceToken string) bool {
+	if binding.IP != "" && binding.IP != ip {
+		return false
+	}
+	if binding.DeviceHash != "" {
+		if deviceToken == "" {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(binding.DeviceHash), []byte(authUtils.HashToken(deviceToken))) == 1
+	}
+	return true
+}
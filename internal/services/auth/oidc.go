@@ -0,0 +1,303 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/models"
+	"lesson-proj/internal/oidc"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"lesson-proj/internal/services/permissions"
+	"log"
+	"strings"
+	"time"
+)
+
+// time to sign in at the provider and come back
+const oidcStateTTL = 10 * time.Minute
+
+// StartOIDCLogin returns the provider URL to sign in with. State (CSRF), nonce (ID token replay)
+// and the PKCE verifier are kept server-side until CompleteOIDCLogin. The returned device token
+// binds the login to this client: without it a callback cannot be completed, so nobody can
+// sign a victim in to their own account by sending them a callback URL (login CSRF).
+func (service *UserService) StartOIDCLogin(ctx context.Context, providerName string) (*models.OIDCAuthorization, error) {
+	return service.startOIDC(ctx, providerName, nil)
+}
+
+// StartOIDCLink is StartOIDCLogin for a signed-in user adding a provider to their account
+func (service *UserService) StartOIDCLink(ctx context.Context, providerName string) (*models.OIDCAuthorization, error) {
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return service.startOIDC(ctx, providerName, &caller.UserID)
+}
+
+// CompleteOIDCLogin finishes the provider redirect. A linked identity signs in its user;
+// otherwise an existing account with the same email is linked only when both the provider
+// and our records have the email verified, and a new account is created for unknown emails.
+// Accounts with 2FA still need the second step (*TwoFactorRequiredError).
+func (service *UserService) CompleteOIDCLogin(ctx context.Context, input models.OIDCCallback) (response *models.AuthResponse, err error) {
+	var (
+		userID int
+		email  string
+		method = "oidc"
+	)
+	defer func() { service.recordLogin(ctx, method, email, userID, err) }()
+
+	state, claims, err := service.exchangeOIDC(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	method, email = "oidc:"+state.Provider, claims.Email
+	// a link flow must finish at CompleteOIDCLink, as the user who started it
+	if state.LinkUserID != nil {
+		return nil, ErrInvalidToken
+	}
+
+	identity, err := service.identities.GetIdentity(ctx, state.Provider, claims.Subject)
+	if err == nil {
+		if err := service.identities.TouchIdentity(ctx, identity.ID, claims.Email); err != nil {
+			log.Printf("failed to update %s identity %d: %v", state.Provider, identity.ID, err)
+		}
+		user, err := service.repository.GetUserWithPasswordByID(ctx, identity.UserID)
+		// the linked account is deactivated or deleted
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrExternalLoginFailed
+		}
+		if err != nil {
+			return nil, err
+		}
+		userID = user.ID
+		return service.completeLogin(ctx, user)
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrExternalEmailRequired
+	}
+	// matched and stored like a registered email
+	claims.Email, err = authUtils.NormalizeEmail(claims.Email)
+	if err != nil {
+		return nil, ErrExternalEmailRequired
+	}
+	email = claims.Email
+	user, err := service.repository.GetUserByEmail(ctx, claims.Email)
+	if errors.Is(err, database.ErrNotFound) {
+		user, err = service.createExternalUser(ctx, claims)
+		// the address still belongs to a deactivated or deleted account
+		if errors.Is(err, database.ErrAlreadyExists) {
+			return nil, ErrExternalLoginFailed
+		}
+	} else if err == nil && !canLinkByEmail(claims, user) {
+		return nil, ErrAccountExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	userID = user.ID
+
+	_, err = service.identities.CreateIdentity(ctx, models.Identity{
+		UserID:   user.ID,
+		Provider: state.Provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	})
+	// the user already has another account at this provider linked
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return nil, ErrAccountExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return service.completeLogin(ctx, user)
+}
+
+// CompleteOIDCLink links the external account to the caller, who must be the user that started the flow
+func (service *UserService) CompleteOIDCLink(ctx context.Context, input models.OIDCCallback) (identity *models.Identity, err error) {
+	defer func() {
+		details := map[string]any{}
+		if identity != nil {
+			details["provider"] = identity.Provider
+		}
+		service.recordUserEvent(ctx, models.AuditIdentityLinked, 0, err, details)
+	}()
+
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	state, claims, err := service.exchangeOIDC(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	if state.LinkUserID == nil || *state.LinkUserID != caller.UserID {
+		return nil, ErrInvalidToken
+	}
+
+	existing, err := service.identities.GetIdentity(ctx, state.Provider, claims.Subject)
+	if err == nil {
+		if existing.UserID != caller.UserID {
+			return nil, ErrIdentityAlreadyLinked
+		}
+		return existing, nil
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+
+	identity, err = service.identities.CreateIdentity(ctx, models.Identity{
+		UserID:   caller.UserID,
+		Provider: state.Provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	})
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return nil, ErrIdentityAlreadyLinked
+	}
+	return identity, err
+}
+
+// GetIdentities lists the external accounts linked to the caller
+func (service *UserService) GetIdentities(ctx context.Context) ([]models.Identity, error) {
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return service.identities.GetIdentitiesByUserID(ctx, caller.UserID)
+}
+
+// UnlinkIdentity removes a provider from the caller's account
+func (service *UserService) UnlinkIdentity(ctx context.Context, providerName string) (err error) {
+	defer func() {
+		service.recordUserEvent(ctx, models.AuditIdentityUnlinked, 0, err, map[string]any{"provider": providerName})
+	}()
+
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return err
+	}
+	return service.identities.DeleteIdentity(ctx, caller.UserID, providerName)
+}
+
+// canLinkByEmail reports whether an external account may sign in to the local account with
+// the same email: the provider and our records must both have verified it, otherwise
+// whoever registered the address (here or at the provider) never proved they own it
+func canLinkByEmail(claims *oidc.Claims, user *models.User) bool {
+	return claims.Email != "" && claims.EmailVerified && user.EmailVerified
+}
+
+func (service *UserService) startOIDC(ctx context.Context, providerName string, linkUserID *int) (*models.OIDCAuthorization, error) {
+	provider, ok := service.oidcProviders[strings.ToLower(providerName)]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	state, err := authUtils.GenerateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := authUtils.GenerateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	deviceToken, err := authUtils.GenerateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	codeVerifier := oidc.GenerateCodeVerifier()
+
+	err = service.identities.CreateLoginState(ctx, models.OIDCLoginState{
+		StateHash:    authUtils.HashToken(state),
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		DeviceHash:   authUtils.HashToken(deviceToken),
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.OIDCAuthorization{
+		AuthorizationURL: provider.AuthCodeURL(state, nonce, codeVerifier),
+		DeviceToken:      deviceToken,
+	}, nil
+}
+
+// exchangeOIDC checks the state and the device token, redeems the code with the PKCE verifier
+// and returns the verified ID token claims
+func (service *UserService) exchangeOIDC(ctx context.Context, input models.OIDCCallback) (*models.OIDCLoginState, *oidc.Claims, error) {
+	if input.State == "" || input.Code == "" || input.DeviceToken == "" {
+		return nil, nil, ErrInvalidToken
+	}
+	// single use: a replayed callback finds no state
+	state, err := service.identities.ConsumeLoginState(ctx, authUtils.HashToken(input.State))
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// the callback comes from another client than the one that started the login
+	if subtle.ConstantTimeCompare([]byte(state.DeviceHash), []byte(authUtils.HashToken(input.DeviceToken))) != 1 {
+		return nil, nil, ErrInvalidToken
+	}
+	provider, ok := service.oidcProviders[state.Provider]
+	if !ok {
+		return nil, nil, ErrUnknownProvider
+	}
+
+	claims, err := provider.Exchange(ctx, input.Code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		// details stay in the log, the client only learns that the login failed
+		log.Printf("%s login failed: %v", state.Provider, err)
+		return nil, nil, ErrExternalLoginFailed
+	}
+	if claims.Subject == "" {
+		return nil, nil, fmt.Errorf("%w: id_token has no subject", ErrExternalLoginFailed)
+	}
+	return state, claims, nil
+}
+
+// createExternalUser registers a user who signed in with a provider. The password is random
+// and never shown: the user signs in with the provider or sets one with ForgotPassword.
+func (service *UserService) createExternalUser(ctx context.Context, claims *oidc.Claims) (*models.User, error) {
+	randomPassword, err := authUtils.GenerateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := service.hashPool.Hash(ctx, randomPassword)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	created, err := service.repository.CreateUser(ctx, models.CreateUser{
+		Email:    claims.Email,
+		Name:     name,
+		Password: hashedPassword,
+		Role:     models.RoleUser,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the provider has verified the address
+	if err := service.repository.MarkEmailVerified(ctx, created.ID); err != nil {
+		return nil, err
+	}
+	return &models.User{
+		ID:             created.ID,
+		Email:          created.Email,
+		Name:           created.Name,
+		HashedPassword: hashedPassword,
+		Role:           created.Role,
+		EmailVerified:  true,
+	}, nil
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"lesson-proj/internal/models"
+	"lesson-proj/internal/oidc"
+	"testing"
+)
+
+func TestCanLinkByEmail(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims oidc.Claims
+		user   models.User
+		want   bool
+	}{
+		{"both verified", oidc.Claims{Email: "alice@example.com", EmailVerified: true}, models.User{EmailVerified: true}, true},
+		{"provider email unverified", oidc.Claims{Email: "alice@example.com"}, models.User{EmailVerified: true}, false},
+		{"local email unverified", oidc.Claims{Email: "alice@example.com", EmailVerified: true}, models.User{}, false},
+		{"neither verified", oidc.Claims{Email: "alice@example.com"}, models.User{}, false},
+		{"no email", oidc.Claims{EmailVerified: true}, models.User{EmailVerified: true}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := canLinkByEmail(&test.claims, &test.user); got != test.want {
+				t.Fatalf("canLinkByEmail = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lesson-proj/internal/authctx"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/mailer"
+	"lesson-proj/internal/models"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"lesson-proj/internal/services/permissions"
+	"log"
+	"net/url"
+	"time"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	// how long the background send of a reset email may take
+	mailSendTimeout = 30 * time.Second
+)
+
+// ChangePassword changes the caller's own password after checking the current one.
+// All sessions, including the current one, are revoked: the client has to log in again.
+func (service *UserService) ChangePassword(ctx context.Context, id int, currentPassword string, newPassword string) (err error) {
+	defer func() { service.recordUserEvent(ctx, models.AuditUserPasswordChanged, id, err, nil) }()
+
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return err
+	}
+	// even admins must not change someone else's password here, they use UpdateUser
+	if caller.UserID != id {
+		return permissions.ErrForbidden
+	}
+
+	user, err := service.repository.GetUserWithPasswordByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := service.verifyCurrentPassword(ctx, user, currentPassword); err != nil {
+		return err
+	}
+	// checked after the current password, so the policy answer does not help guessing it
+	if err := authUtils.ValidateNewPassword("new_password", newPassword, user.Email, user.Name); err != nil {
+		return err
+	}
+
+	return service.setPassword(ctx, id, newPassword)
+}
+
+// ForgotPassword emails a password reset link if an account with this email exists.
+// The result is the same whether it exists or not, and the email is sent in the background,
+// so neither the response nor its timing tells an attacker which emails are registered.
+func (service *UserService) ForgotPassword(ctx context.Context, email string) (err error) {
+	var userID int
+	defer func() {
+		service.recordUserEvent(ctx, models.AuditUserPasswordResetSent, userID, err, map[string]any{"email": email})
+	}()
+
+	if normalized, err := authUtils.NormalizeEmail(email); err == nil {
+		email = normalized
+	}
+	user, err := service.repository.GetUserByEmail(ctx, email)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	userID = user.ID
+
+	// the request may finish before the mail is sent, keep the context values but not its cancellation
+	mailCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailSendTimeout)
+	go func() {
+		defer cancel()
+		if err := service.sendPasswordResetEmail(mailCtx, user.ID, user.Email, user.Name); err != nil {
+			log.Printf("failed to send password reset email to user %d: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+// ResetPassword redeems a reset token, sets the new password and revokes all sessions
+func (service *UserService) ResetPassword(ctx context.Context, token string, newPassword string) (err error) {
+	var userID int
+	defer func() { service.recordUserEvent(ctx, models.AuditUserPasswordReset, userID, err, nil) }()
+
+	if token == "" {
+		return ErrInvalidToken
+	}
+	tokenHash := authUtils.HashToken(token)
+	// a rejected password must not use up the link, so it is checked before ConsumeToken
+	pending, err := service.oneTimeTokens.GetValidToken(ctx, models.TokenPurposePasswordReset, tokenHash)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	user, err := service.repository.GetUserByID(ctx, pending.UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	if err := authUtils.ValidateNewPassword("new_password", newPassword, user.Email, user.Name); err != nil {
+		return err
+	}
+
+	consumed, err := service.oneTimeTokens.ConsumeToken(ctx, models.TokenPurposePasswordReset, tokenHash)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	userID = consumed.UserID
+	// other reset links sent before this one must not work anymore
+	if err := service.oneTimeTokens.InvalidateUserTokens(ctx, consumed.UserID, models.TokenPurposePasswordReset); err != nil {
+		return err
+	}
+	return service.setPassword(ctx, consumed.UserID, newPassword)
+}
+
+func (service *UserService) sendPasswordResetEmail(ctx context.Context, userID int, email string, name string) error {
+	if err := service.oneTimeTokens.InvalidateUserTokens(ctx, userID, models.TokenPurposePasswordReset); err != nil {
+		return err
+	}
+	token, err := service.issueOneTimeToken(ctx, userID, models.TokenPurposePasswordReset, "", passwordResetTTL)
+	if err != nil {
+		return err
+	}
+	link := service.appBaseURL + "/reset-password?token=" + url.QueryEscape(token)
+	return service.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nsomeone asked to reset the password of your account. Open this link to choose a new one:\n%s\n\n"+
+				"The link is valid for 1 hour and works once. If it was not you, ignore this email.",
+			name, link,
+		),
+	})
+}
+
+// rehashIfNeeded re-hashes the password with the current Argon2 parameters and pepper
+// when the stored hash is outdated (this is how hashes migrate to a new pepper). Errors are only logged: the login already succeeded
+// and the upgrade will be retried on the next one.
+func (service *UserService) rehashIfNeeded(ctx context.Context, user *models.User, password string) {
+	if !authUtils.NeedsRehash(user.HashedPassword) {
+		return
+	}
+	newHash, err := service.hashPool.Hash(ctx, password)
+	if err != nil {
+		log.Printf("failed to rehash password of user %d: %v", user.ID, err)
+		return
+	}
+	if _, err := service.repository.ReplacePasswordHash(ctx, user.ID, user.HashedPassword, newHash); err != nil {
+		log.Printf("failed to store rehashed password of user %d: %v", user.ID, err)
+	}
+}
+
+// verifyCurrentPassword checks the password a signed-in user confirms a change with.
+// Failures count towards the login lockout like failed logins, so a stolen access token
+// cannot be used to guess the password through these endpoints.
+func (service *UserService) verifyCurrentPassword(ctx context.Context, user *models.User, password string) error {
+	ip := authctx.ClientFromContext(ctx).IP
+	if err := service.loginLimiter.check(ctx, user.Email, ip); err != nil {
+		return err
+	}
+	ok, err := service.hashPool.Verify(ctx, password, user.HashedPassword)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		service.loginLimiter.recordFailure(ctx, user.Email, ip)
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// setPassword hashes and stores a new password and revokes every existing session
+func (service *UserService) setPassword(ctx context.Context, id int, password string) error {
+	hashPassword, err := service.hashPool.Hash(ctx, password)
+	if err != nil {
+		return err
+	}
+	if err := service.repository.UpdatePassword(ctx, id, hashPassword); err != nil {
+		return err
+	}
+	return service.refreshTokens.RevokeAllForUser(ctx, id)
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/mailer"
+	"lesson-proj/internal/models"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"lesson-proj/internal/services/permissions"
+	"log"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultErasureGracePeriod = 30 * 24 * time.Hour
+	// accounts erased per run of the erasure job, the rest waits for the next run
+	erasureBatchSize = 100
+)
+
+// ExportUserData collects everything stored about the caller: profile, linked accounts,
+// API keys (without the keys), login history, products and the audit events about them.
+// Deleted accounts cannot sign in, so the data is downloaded before DELETE /users/{id}.
+func (service *UserService) ExportUserData(ctx context.Context) (export *models.DataExport, err error) {
+	var userID int
+	defer func() { service.recordUserEvent(ctx, models.AuditUserDataExported, userID, err, nil) }()
+
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	userID = caller.UserID
+
+	profile, err := service.repository.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	export = &models.DataExport{
+		ExportedAt: time.Now().UTC(),
+		Profile:    *profile,
+	}
+	twoFactor, err := service.repository.GetTwoFactorState(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	export.TwoFactorEnabled = twoFactor.Enabled
+	if export.Identities, err = service.identities.GetIdentitiesByUserID(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.APIKeys, err = service.apiKeys.GetAPIKeysByUserID(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.Logins, err = service.logins.GetLoginsByUserID(ctx, userID, 0); err != nil {
+		return nil, err
+	}
+	if export.Products, err = service.products.GetAllProducts(ctx, models.ProductFilter{SellerID: &userID, IncludeDeleted: true}); err != nil {
+		return nil, err
+	}
+	if export.AuditEvents, err = service.audit.UserEvents(ctx, userID); err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// DeleteUser soft deletes the caller's own account, admins can delete any account.
+// It is hidden, cannot sign in and every session is signed out. Until the grace period
+// is over the user can restore it with the link from the notice (RestoreDeletedAccount)
+// and admins with RestoreUser, then CompleteDueErasures erases it.
+func (service *UserService) DeleteUser(ctx context.Context, id int) (scheduled *models.ErasureScheduled, err error) {
+	var scheduledFor time.Time
+	defer func() {
+		var details map[string]any
+		if !scheduledFor.IsZero() {
+			details = map[string]any{"erasure_scheduled_for": scheduledFor}
+		}
+		service.recordUserEvent(ctx, models.AuditUserDeleted, id, err, details)
+	}()
+
+	if err := permissions.RequireOwnerOrAdmin(ctx, id); err != nil {
+		return nil, err
+	}
+	user, scheduledFor, err := service.repository.DeleteUser(ctx, id, time.Now().Add(service.erasureGracePeriod))
+	if err != nil {
+		return nil, err
+	}
+	if err := service.revokeAllSessions(ctx, id); err != nil {
+		return nil, err
+	}
+	restoreToken, err := service.issueOneTimeToken(ctx, id, models.TokenPurposeAccountRestore, "", time.Until(scheduledFor))
+	if err != nil {
+		return nil, err
+	}
+
+	// the request may finish before the mail is sent, keep the context values but not its cancellation
+	mailCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailSendTimeout)
+	go func() {
+		defer cancel()
+		if err := service.sendAccountDeletedEmail(mailCtx, *user, scheduledFor, restoreToken); err != nil {
+			log.Printf("failed to send deletion notice to user %d: %v", id, err)
+		}
+	}()
+	return &models.ErasureScheduled{
+		Message:      "The account was deleted and will be erased after the grace period, use the link in the email to restore it",
+		ScheduledFor: scheduledFor,
+	}, nil
+}
+
+// RestoreDeletedAccount redeems the restore link of a deleted account,
+// the user signs in again afterwards
+func (service *UserService) RestoreDeletedAccount(ctx context.Context, token string) (err error) {
+	var userID int
+	defer func() {
+		service.recordUserEvent(ctx, models.AuditUserRestored, userID, err, map[string]any{"via": "restore_link"})
+	}()
+
+	if token == "" {
+		return ErrInvalidToken
+	}
+	user, err := service.repository.RestoreDeletedUser(ctx, authUtils.HashToken(token))
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	userID = user.ID
+	return nil
+}
+
+// DeactivateUser suspends an account, admins only. It is hidden, cannot sign in and
+// its API keys stop working until RestoreUser; unlike a deleted account it is never erased.
+func (service *UserService) DeactivateUser(ctx context.Context, id int) (err error) {
+	defer func() { service.recordUserEvent(ctx, models.AuditUserDeactivated, id, err, nil) }()
+
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return err
+	}
+	if err := permissions.RequireAdmin(ctx); err != nil {
+		return err
+	}
+	// like demoting themselves, this could leave the system without any admin
+	if caller.UserID == id {
+		return ErrCannotDeactivateSelf
+	}
+	if err := service.repository.DeactivateUser(ctx, id); err != nil {
+		return err
+	}
+	return service.revokeAllSessions(ctx, id)
+}
+
+// RestoreUser reactivates a deactivated or deleted account that is not erased yet, admins only
+func (service *UserService) RestoreUser(ctx context.Context, id int) (restoredUser *models.UserWithoutPassword, err error) {
+	defer func() {
+		service.recordUserEvent(ctx, models.AuditUserRestored, id, err, map[string]any{"via": "admin"})
+	}()
+
+	if err := permissions.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return service.repository.RestoreUser(ctx, id)
+}
+
+// CompleteDueErasures erases the accounts whose grace period is over, run by the erasure job.
+// It returns how many accounts were erased; one failed account does not stop the others.
+func (service *UserService) CompleteDueErasures(ctx context.Context) (int, error) {
+	ids, err := service.repository.GetDueErasures(ctx, erasureBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	erased := 0
+	var errs []error
+	for _, id := range ids {
+		user, err := service.repository.EraseUser(ctx, id)
+		// canceled in the meantime, or erased by another instance
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %w", id, err))
+			continue
+		}
+		erased++
+		service.audit.Record(ctx, models.AuditEvent{
+			Type:       models.AuditUserErased,
+			TargetType: models.AuditTargetUser,
+			TargetID:   &id,
+			UserAgent:  "erasure job",
+		}, nil)
+		// the address is gone from the database, this is the last email it gets
+		if err := service.sendErasureCompletedEmail(ctx, *user); err != nil {
+			log.Printf("failed to send erasure confirmation to user %d: %v", id, err)
+		}
+	}
+	return erased, errors.Join(errs...)
+}
+
+func (service *UserService) sendAccountDeletedEmail(ctx context.Context, user models.UserWithoutPassword, scheduledFor time.Time, restoreToken string) error {
+	return service.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your account was deleted",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nyour account was deleted and all devices were signed out. "+
+				"Your personal data will be erased on %s.\n\n"+
+				"Changed your mind? Restore the account before then:\n%s",
+			user.Name, scheduledFor.UTC().Format(time.RFC1123),
+			service.appBaseURL+"/restore-account?token="+url.QueryEscape(restoreToken),
+		),
+	})
+}
+
+func (service *UserService) sendErasureCompletedEmail(ctx context.Context, user models.UserWithoutPassword) error {
+	return service.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your personal data was erased",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nyour account and personal data were erased. Listings you posted stay online as \"Deleted user\".",
+			user.Name,
+		),
+	})
+}
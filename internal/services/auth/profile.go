@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/models"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"lesson-proj/internal/services/permissions"
+	"strings"
+)
+
+// GetProfile returns the caller's own profile, including the email
+func (service *UserService) GetProfile(ctx context.Context) (*models.Profile, error) {
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return service.repository.GetProfile(ctx, caller.UserID)
+}
+
+// UpdateProfile changes the profile fields of the caller that are sent,
+// an empty string removes a field. The handle is stored normalized (see authUtils.NormalizeHandle).
+func (service *UserService) UpdateProfile(ctx context.Context, input models.UpdateProfile) (profile *models.Profile, err error) {
+	var userID int
+	defer func() {
+		service.recordUserEvent(ctx, models.AuditUserProfileUpdated, userID, err, map[string]any{
+			"display_name_changed": input.DisplayName != nil,
+			"handle_changed":       input.Handle != nil,
+			"bio_changed":          input.Bio != nil,
+			"avatar_changed":       input.AvatarURL != nil,
+			"status_changed":       input.StatusText != nil || input.StatusEmoji != nil,
+		})
+	}()
+
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	userID = caller.UserID
+
+	for _, field := range []*string{input.DisplayName, input.Handle, input.Bio, input.AvatarURL, input.StatusText, input.StatusEmoji} {
+		if field != nil {
+			*field = strings.TrimSpace(*field)
+		}
+	}
+	if err := authUtils.ValidateProfileInput(input); err != nil {
+		return nil, err
+	}
+	if input.Handle != nil && *input.Handle != "" {
+		handle, err := authUtils.NormalizeHandle(*input.Handle)
+		if err != nil {
+			return nil, err
+		}
+		input.Handle = &handle
+	}
+
+	profile, err = service.repository.UpdateProfile(ctx, caller.UserID, input)
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return nil, ErrHandleTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// GetPublicProfile returns the profile of any active user as other users see it, without the email
+func (service *UserService) GetPublicProfile(ctx context.Context, id int) (*models.PublicProfile, error) {
+	if _, err := permissions.Caller(ctx); err != nil {
+		return nil, err
+	}
+	return service.repository.GetPublicProfile(ctx, id)
+}
+
+// GetPublicProfileByHandle is GetPublicProfile for an @handle
+func (service *UserService) GetPublicProfileByHandle(ctx context.Context, handle string) (*models.PublicProfile, error) {
+	if _, err := permissions.Caller(ctx); err != nil {
+		return nil, err
+	}
+	// no account can have an invalid handle
+	normalized, err := authUtils.NormalizeHandle(handle)
+	if err != nil {
+		return nil, fmt.Errorf("user with handle %s %w", handle, database.ErrNotFound)
+	}
+	return service.repository.GetPublicProfileByHandle(ctx, normalized)
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"lesson-proj/internal/authctx"
+	"lesson-proj/internal/mailer"
+	"lesson-proj/internal/models"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"lesson-proj/internal/services/permissions"
+	"log"
+	"time"
+)
+
+// logins returned by GetLogins
+const loginHistoryLimit = 50
+
+// GetSessions lists the caller's signed-in devices, most recently active first
+func (service *UserService) GetSessions(ctx context.Context) ([]models.Session, error) {
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return service.logins.GetActiveSessions(ctx, caller.UserID)
+}
+
+// GetLogins returns the caller's latest successful logins, newest first
+func (service *UserService) GetLogins(ctx context.Context) ([]models.Login, error) {
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return service.logins.GetLoginsByUserID(ctx, caller.UserID, loginHistoryLimit)
+}
+
+// recordLoginHistory stores where a new session comes from and warns the user
+// by email when it is the first login from this device (label and network)
+func (service *UserService) recordLoginHistory(ctx context.Context, user models.UserWithoutPassword, familyID string) error {
+	client := authctx.ClientFromContext(ctx)
+	login, newDevice, err := service.logins.CreateLogin(ctx, models.Login{
+		UserID:      user.ID,
+		FamilyID:    familyID,
+		IP:          client.IP,
+		UserAgent:   authUtils.TruncateUserAgent(client.UserAgent),
+		DeviceLabel: authUtils.DeviceLabel(client.UserAgent),
+		Network:     authUtils.DeviceNetwork(client.IP),
+	})
+	if err != nil {
+		return err
+	}
+	if !newDevice {
+		return nil
+	}
+
+	// the login must not wait for the mail server
+	mailCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailSendTimeout)
+	go func() {
+		defer cancel()
+		if err := service.sendNewDeviceAlert(mailCtx, user, *login); err != nil {
+			log.Printf("failed to send new device alert to user %d: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+func (service *UserService) sendNewDeviceAlert(ctx context.Context, user models.UserWithoutPassword, login models.Login) error {
+	ip := login.IP
+	if ip == "" {
+		ip = "unknown"
+	}
+	return service.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "New sign-in to your account",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nyour account was just signed in from a new device:\n\n"+
+				"Device: %s\nIP address: %s\nTime: %s\n\n"+
+				"If this was you, there is nothing to do.\n"+
+				"If not, change your password and sign out all devices right away.",
+			user.Name, login.DeviceLabel, ip, login.CreatedAt.UTC().Format(time.RFC1123),
+		),
+	})
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"lesson-proj/internal/authctx"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/models"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"log"
+	"time"
+)
+
+// AuthenticateAccessToken validates a bearer token and returns its owner.
+// The user is loaded from the DB so role changes, deletions and
+// session revocation (password change, logout from all devices) apply immediately.
+func (service *UserService) AuthenticateAccessToken(ctx context.Context, accessToken string) (*authctx.Principal, error) {
+	claims, err := service.tokens.ParseAccessToken(accessToken)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	state, err := service.repository.GetUserAuthState(ctx, claims.UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	// both times have microsecond precision; a token from the very microsecond of the revocation is rejected too
+	if state.SessionsRevokedAt != nil && !claims.IssuedAt.After(*state.SessionsRevokedAt) {
+		return nil, ErrInvalidToken
+	}
+	// presence for the profile, API keys are scripts and do not count
+	if err := service.repository.TouchLastSeen(ctx, state.ID); err != nil {
+		log.Printf("failed to update last seen of user %d: %v", state.ID, err)
+	}
+	return &authctx.Principal{
+		UserID:        state.ID,
+		Role:          state.Role,
+		EmailVerified: state.EmailVerified,
+	}, nil
+}
+
+// RefreshTokens rotates a refresh token: the presented token becomes unusable
+// and a new access/refresh pair from the same family is returned.
+// Presenting an already rotated token revokes the whole family.
+func (service *UserService) RefreshTokens(ctx context.Context, refreshToken string) (*models.AuthResponse, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidToken
+	}
+	newRefreshToken, newHash, refreshExpiresAt, err := service.tokens.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	rotated, err := service.refreshTokens.RotateRefreshToken(ctx, authUtils.HashToken(refreshToken), newHash, refreshExpiresAt)
+	if errors.Is(err, database.ErrRefreshTokenReused) {
+		// a stolen token or a client bug, either way worth a look
+		service.recordUserEvent(ctx, models.AuditRefreshTokenReused, 0, ErrRefreshTokenReused, nil)
+		return nil, ErrRefreshTokenReused
+	}
+	if errors.Is(err, database.ErrRefreshTokenNotFound) || errors.Is(err, database.ErrRefreshTokenExpired) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := service.repository.GetUserByID(ctx, rotated.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return service.buildAuthResponse(*user, newRefreshToken, refreshExpiresAt)
+}
+
+// Logout revokes the session (token family) the refresh token belongs to
+func (service *UserService) Logout(ctx context.Context, refreshToken string) (err error) {
+	defer func() { service.recordUserEvent(ctx, models.AuditUserLogout, 0, err, nil) }()
+
+	if refreshToken == "" {
+		return ErrInvalidToken
+	}
+	return service.refreshTokens.RevokeFamilyByTokenHash(ctx, authUtils.HashToken(refreshToken))
+}
+
+// LogoutAll revokes every session of the user, e.g. after a device was lost
+func (service *UserService) LogoutAll(ctx context.Context, userID int) error {
+	err := service.revokeAllSessions(ctx, userID)
+	service.recordUserEvent(ctx, models.AuditUserLogoutAll, userID, err, nil)
+	return err
+}
+
+// revokeAllSessions revokes all refresh tokens and every access token issued so far
+func (service *UserService) revokeAllSessions(ctx context.Context, userID int) error {
+	if err := service.refreshTokens.RevokeAllForUser(ctx, userID); err != nil {
+		return err
+	}
+	return service.repository.RevokeSessions(ctx, userID)
+}
+
+// startSession creates a new token family for the user and returns the first token pair
+func (service *UserService) startSession(ctx context.Context, user models.UserWithoutPassword) (*models.AuthResponse, error) {
+	familyID, err := authUtils.GenerateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshHash, refreshExpiresAt, err := service.tokens.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := service.recordLoginHistory(ctx, user, familyID); err != nil {
+		return nil, err
+	}
+	err = service.refreshTokens.CreateRefreshToken(ctx, models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: refreshHash,
+		ExpiresAt: refreshExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return service.buildAuthResponse(user, refreshToken, refreshExpiresAt)
+}
+
+func (service *UserService) buildAuthResponse(user models.UserWithoutPassword, refreshToken string, refreshExpiresAt time.Time) (*models.AuthResponse, error) {
+	accessToken, expiresAt, err := service.tokens.GenerateAccessToken(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.AuthResponse{
+		AccessToken:           accessToken,
+		TokenType:             "Bearer",
+		ExpiresAt:             expiresAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: refreshExpiresAt,
+		User:                  user,
+	}, nil
+}
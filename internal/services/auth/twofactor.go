@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"lesson-proj/internal/authctx"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/models"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"lesson-proj/internal/services/permissions"
+	"log"
+	"time"
+)
+
+const (
+	// time to type the code after a correct password
+	twoFactorChallengeTTL = 5 * time.Minute
+	recoveryCodeCount     = 10
+)
+
+// EnrollTwoFactor creates a new TOTP secret for the caller. It is not enforced
+// until ConfirmTwoFactor proves the authenticator app produces valid codes.
+// The password is required so a stolen access token cannot enable 2FA on someone else's account.
+func (service *UserService) EnrollTwoFactor(ctx context.Context, password string) (enrollment *models.TwoFactorEnrollment, err error) {
+	defer func() { service.recordUserEvent(ctx, models.AuditTwoFactorEnrolled, 0, err, nil) }()
+
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if service.secretBox == nil {
+		return nil, ErrTwoFactorUnavailable
+	}
+
+	user, err := service.repository.GetUserWithPasswordByID(ctx, caller.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	ok, err := service.hashPool.Verify(ctx, password, user.HashedPassword)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidPassword
+	}
+
+	secret, err := authUtils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encryptedSecret, err := service.secretBox.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+	err = service.repository.SetPendingTOTPSecret(ctx, user.ID, encryptedSecret)
+	// 2FA was confirmed by a parallel request
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorEnrollment{
+		Secret:          secret,
+		ProvisioningURI: authUtils.TOTPProvisioningURI(service.totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTwoFactor enables 2FA after the first valid code
+// and returns recovery codes, which are never shown again
+func (service *UserService) ConfirmTwoFactor(ctx context.Context, code string) (codes *models.RecoveryCodes, err error) {
+	defer func() { service.recordUserEvent(ctx, models.AuditTwoFactorEnabled, 0, err, nil) }()
+
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if service.secretBox == nil {
+		return nil, ErrTwoFactorUnavailable
+	}
+
+	state, err := service.repository.GetTwoFactorState(ctx, caller.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if state.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if state.EncryptedSecret == nil {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	ok, err := service.checkTOTP(ctx, caller.UserID, *state.EncryptedSecret, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, err = service.replaceRecoveryCodes(ctx, caller.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.repository.EnableTOTP(ctx, caller.UserID); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CompleteTwoFactorLogin exchanges a login challenge and a TOTP or recovery code for tokens.
+// Wrong codes count as failed logins of the account, so guessing is locked out like passwords are;
+// the challenge itself stays valid until it expires or a code is accepted.
+func (service *UserService) CompleteTwoFactorLogin(ctx context.Context, input models.TwoFactorLogin) (response *models.AuthResponse, err error) {
+	var (
+		userID int
+		email  string
+	)
+	defer func() {
+		method := "totp"
+		if input.RecoveryCode != "" {
+			method = "recovery_code"
+		}
+		service.recordLogin(ctx, method, email, userID, err)
+	}()
+
+	if input.ChallengeToken == "" {
+		return nil, ErrInvalidToken
+	}
+	challengeHash := authUtils.HashToken(input.ChallengeToken)
+	challenge, err := service.oneTimeTokens.GetValidToken(ctx, models.TokenPurposeTwoFactorChallenge, challengeHash)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	user, err := service.repository.GetUserWithPasswordByID(ctx, challenge.UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	userID, email = user.ID, user.Email
+
+	ip := authctx.ClientFromContext(ctx).IP
+	if err := service.loginLimiter.check(ctx, user.Email, ip); err != nil {
+		return nil, err
+	}
+	ok, err := service.verifySecondFactor(ctx, user.ID, input.Code, input.RecoveryCode)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		service.loginLimiter.recordFailure(ctx, user.Email, ip)
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	// a challenge can finish only one login, even with two valid codes sent in parallel
+	if _, err := service.oneTimeTokens.ConsumeToken(ctx, models.TokenPurposeTwoFactorChallenge, challengeHash); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if err := service.loginLimiter.reset(ctx, models.LoginScopeAccount, accountKey(user.Email)); err != nil {
+		log.Printf("failed to reset login attempts of user %d: %v", user.ID, err)
+	}
+	return service.startSession(ctx, userWithoutPassword(user))
+}
+
+// ResetTwoFactor turns 2FA off for a user who lost their device and recovery codes, admins only
+func (service *UserService) ResetTwoFactor(ctx context.Context, id int) (err error) {
+	defer func() { service.recordUserEvent(ctx, models.AuditTwoFactorReset, id, err, nil) }()
+
+	if err := permissions.RequireAdmin(ctx); err != nil {
+		return err
+	}
+	if err := service.repository.ResetTOTP(ctx, id); err != nil {
+		return err
+	}
+	if err := service.recoveryCodes.DeleteForUser(ctx, id); err != nil {
+		return err
+	}
+	// pending challenges would otherwise ask for a code the user no longer has
+	return service.oneTimeTokens.InvalidateUserTokens(ctx, id, models.TokenPurposeTwoFactorChallenge)
+}
+
+// completeLogin is the last step of every login method: it starts a session,
+// or asks for the second factor if the account has 2FA enabled
+func (service *UserService) completeLogin(ctx context.Context, user *models.User) (*models.AuthResponse, error) {
+	if user.TwoFactorEnabled {
+		challengeToken, err := service.issueOneTimeToken(ctx, user.ID, models.TokenPurposeTwoFactorChallenge, "", twoFactorChallengeTTL)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &TwoFactorRequiredError{Challenge: models.TwoFactorChallenge{
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
+			ExpiresAt:         time.Now().Add(twoFactorChallengeTTL),
+		}}
+	}
+	// every login starts a new token family (one per device/session)
+	return service.startSession(ctx, userWithoutPassword(user))
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code
+func (service *UserService) verifySecondFactor(ctx context.Context, userID int, code string, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		codeHash := authUtils.HashToken(authUtils.NormalizeRecoveryCode(recoveryCode))
+		return service.recoveryCodes.UseCode(ctx, userID, codeHash)
+	}
+
+	state, err := service.repository.GetTwoFactorState(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if !state.Enabled || state.EncryptedSecret == nil {
+		return false, nil
+	}
+	if service.secretBox == nil {
+		return false, ErrTwoFactorUnavailable
+	}
+	return service.checkTOTP(ctx, userID, *state.EncryptedSecret, code)
+}
+
+// checkTOTP validates a code against the encrypted secret and burns its time step
+func (service *UserService) checkTOTP(ctx context.Context, userID int, encryptedSecret string, code string) (bool, error) {
+	secret, err := service.secretBox.Open(encryptedSecret)
+	if err != nil {
+		return false, err
+	}
+	step, ok := authUtils.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return service.repository.UseTOTPStep(ctx, userID, step)
+}
+
+func (service *UserService) replaceRecoveryCodes(ctx context.Context, userID int) (*models.RecoveryCodes, error) {
+	codes, err := authUtils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, authUtils.HashToken(authUtils.NormalizeRecoveryCode(code)))
+	}
+	if err := service.recoveryCodes.ReplaceCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return &models.RecoveryCodes{Codes: codes}, nil
+}
+
+func userWithoutPassword(user *models.User) models.UserWithoutPassword {
+	return models.UserWithoutPassword{
+		ID:            user.ID,
+		Email:         user.Email,
+		Name:          user.Name,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+	}
+}
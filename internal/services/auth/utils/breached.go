@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// breachedPrefixLength is the number of hex characters of the SHA-1 hash
+// used as bucket key, the same split as the Pwned Passwords range API
+const breachedPrefixLength = 5
+
+// BreachedPasswords is an offline list of leaked passwords, indexed like a
+// k-anonymity range API: SHA-1 hashes are grouped by their first 5 hex characters
+// and a lookup only asks for the suffixes of one prefix. The list can therefore be
+// swapped for a remote range service without ever sending a password or its full hash.
+type BreachedPasswords struct {
+	// prefix -> sorted hash suffixes (uppercase hex)
+	buckets map[string][]string
+	count   int
+}
+
+// LoadBreachedPasswords reads a list file, one entry per line. An entry is either
+// an uppercase or lowercase SHA-1 hex hash, optionally followed by ":count"
+// (the downloadable Pwned Passwords format), or a plaintext password.
+// Empty lines and lines starting with # are skipped.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	list, err := ReadBreachedPasswords(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return list, nil
+}
+
+// ReadBreachedPasswords builds the index from r, see LoadBreachedPasswords for the format
+func ReadBreachedPasswords(r io.Reader) (*BreachedPasswords, error) {
+	list := &BreachedPasswords{buckets: make(map[string][]string)}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, ok := parseBreachedHash(line)
+		if !ok {
+			hash = breachedHash(line)
+		}
+		prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+		list.buckets[prefix] = append(list.buckets[prefix], suffix)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+	}
+
+	for prefix, suffixes := range list.buckets {
+		sort.Strings(suffixes)
+		// drop duplicates so Count is the number of distinct passwords
+		unique := suffixes[:0]
+		for i, suffix := range suffixes {
+			if i == 0 || suffix != suffixes[i-1] {
+				unique = append(unique, suffix)
+			}
+		}
+		list.buckets[prefix] = unique
+		list.count += len(unique)
+	}
+	return list, nil
+}
+
+// Count returns the number of distinct passwords in the list
+func (list *BreachedPasswords) Count() int {
+	if list == nil {
+		return 0
+	}
+	return list.count
+}
+
+// Range returns the sorted hash suffixes stored under a 5 character prefix
+func (list *BreachedPasswords) Range(prefix string) []string {
+	if list == nil {
+		return nil
+	}
+	return list.buckets[strings.ToUpper(prefix)]
+}
+
+// Contains reports whether the password is in the list. A nil list contains nothing.
+func (list *BreachedPasswords) Contains(password string) bool {
+	hash := breachedHash(password)
+	suffixes := list.Range(hash[:breachedPrefixLength])
+	suffix := hash[breachedPrefixLength:]
+	i := sort.SearchStrings(suffixes, suffix)
+	return i < len(suffixes) && suffixes[i] == suffix
+}
+
+func breachedHash(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// parseBreachedHash accepts "HASH" or "HASH:count" with a 40 character hex SHA-1 hash
+func parseBreachedHash(line string) (string, bool) {
+	hash, _, _ := strings.Cut(line, ":")
+	if len(hash) != 2*sha1.Size {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+	return strings.ToUpper(hash), true
+}
@@ -1,9 +1,110 @@
 package utils
 
-const (
-	timeCost = uint32(2)         // number of iterations
-	memoryKB = uint32(64 * 1024) // memory cost in KiB
-	threads  = uint8(2)          // number of parallel threads
-	keyLen   = uint32(32)        // length of the derived key in bytes
-	saltLen  = 16                // length of salt in bytes
-)
\ No newline at end of file
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Argon2Params are the cost parameters used for new password hashes.
+// Existing hashes keep the parameters stored inside them, and are upgraded
+// on the next successful login when these values change (see NeedsRehash).
+type Argon2Params struct {
+	TimeCost uint32 // number of iterations
+	MemoryKB uint32 // memory cost in KiB
+	Threads  uint8  // number of parallel threads
+	KeyLen   uint32 // length of the derived key in bytes
+	SaltLen  uint32 // length of salt in bytes
+}
+
+// DefaultArgon2Params returns the parameters used when nothing is configured
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		TimeCost: 2,
+		MemoryKB: 64 * 1024,
+		Threads:  2,
+		KeyLen:   32,
+		SaltLen:  16,
+	}
+}
+
+// argon2Params is set once at startup by ConfigureArgon2 and only read afterwards
+var argon2Params = DefaultArgon2Params()
+
+// ConfigureArgon2 sets the parameters for new hashes, call it at startup before serving requests
+func ConfigureArgon2(params Argon2Params) error {
+	if params.TimeCost < 1 {
+		return errors.New("argon2 time cost must be at least 1")
+	}
+	if params.Threads < 1 {
+		return errors.New("argon2 threads must be at least 1")
+	}
+	// RFC 9106: memory must be at least 8 KiB per thread
+	if params.MemoryKB < 8*uint32(params.Threads) {
+		return errors.New("argon2 memory must be at least 8 KiB per thread")
+	}
+	if params.KeyLen < 16 || params.SaltLen < 16 {
+		return errors.New("argon2 key and salt must be at least 16 bytes")
+	}
+	argon2Params = params
+	return nil
+}
+
+// CurrentArgon2Params returns the parameters used for new hashes
+func CurrentArgon2Params() Argon2Params {
+	return argon2Params
+}
+
+// LoadArgon2ParamsFromEnv reads ARGON2_TIME, ARGON2_MEMORY_KB and ARGON2_THREADS,
+// unset variables keep their default value
+func LoadArgon2ParamsFromEnv() (Argon2Params, error) {
+	params := DefaultArgon2Params()
+	if err := uintFromEnv("ARGON2_TIME", 32, func(value uint64) { params.TimeCost = uint32(value) }); err != nil {
+		return params, err
+	}
+	if err := uintFromEnv("ARGON2_MEMORY_KB", 32, func(value uint64) { params.MemoryKB = uint32(value) }); err != nil {
+		return params, err
+	}
+	if err := uintFromEnv("ARGON2_THREADS", 8, func(value uint64) { params.Threads = uint8(value) }); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+// ConfigureFromEnv loads Argon2 parameters, the password policy and peppers from env,
+// both binaries (api and admin) call it once at startup
+func ConfigureFromEnv() error {
+	params, err := LoadArgon2ParamsFromEnv()
+	if err != nil {
+		return err
+	}
+	if err := ConfigureArgon2(params); err != nil {
+		return err
+	}
+	policy, err := LoadPasswordPolicyFromEnv()
+	if err != nil {
+		return err
+	}
+	if err := ConfigurePasswordPolicy(policy); err != nil {
+		return err
+	}
+	currentPepperID, secrets, err := LoadPeppersFromEnv()
+	if err != nil {
+		return err
+	}
+	return ConfigurePeppers(currentPepperID, secrets)
+}
+
+func uintFromEnv(key string, bitSize int, set func(uint64)) error {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return nil
+	}
+	value, err := strconv.ParseUint(raw, 10, bitSize)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	set(value)
+	return nil
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// longest user agent kept in the login history, longer ones are cut
+const maxUserAgentLength = 512
+
+// prefix lengths of the network a device signs in from: a home or office
+// network keeps its prefix while the address inside it changes
+const (
+	deviceNetworkBitsIPv4 = 24
+	deviceNetworkBitsIPv6 = 48
+)
+
+// DeviceLabel derives a short, human-readable device name like "Firefox on Linux"
+// from a User-Agent header. Browser versions are left out on purpose: the label
+// identifies a device, and it must not change with every browser update.
+func DeviceLabel(userAgent string) string {
+	if strings.TrimSpace(userAgent) == "" {
+		return "Unknown device"
+	}
+	browser, system := userAgentBrowser(userAgent), userAgentSystem(userAgent)
+	if system == "" {
+		return browser
+	}
+	return browser + " on " + system
+}
+
+// DeviceNetwork returns the network of a client IP ("203.0.113.0/24", "2001:db8:1::/48").
+// Together with the device label it tells a known device from a new one,
+// since the label alone is the same for everyone with the same browser and system.
+// Unparsable or unknown addresses give "".
+func DeviceNetwork(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	bits := deviceNetworkBitsIPv6
+	if addr.Is4() {
+		bits = deviceNetworkBitsIPv4
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+// TruncateUserAgent cuts a User-Agent header to the length stored with logins
+func TruncateUserAgent(userAgent string) string {
+	if len(userAgent) <= maxUserAgentLength {
+		return userAgent
+	}
+	// do not cut a multi-byte character in half
+	cut := maxUserAgentLength
+	for cut > 0 && !isRuneStart(userAgent[cut]) {
+		cut--
+	}
+	return userAgent[:cut]
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// userAgentBrowser checks the more specific tokens first:
+// Edge and Opera also send "Chrome", Chrome also sends "Safari"
+func userAgentBrowser(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "Edg/"), strings.Contains(userAgent, "EdgA/"), strings.Contains(userAgent, "EdgiOS/"):
+		return "Edge"
+	case strings.Contains(userAgent, "OPR/"), strings.Contains(userAgent, "Opera"):
+		return "Opera"
+	case strings.Contains(userAgent, "SamsungBrowser/"):
+		return "Samsung Internet"
+	case strings.Contains(userAgent, "Firefox/"), strings.Contains(userAgent, "FxiOS/"):
+		return "Firefox"
+	case strings.Contains(userAgent, "Chrome/"), strings.Contains(userAgent, "CriOS/"), strings.Contains(userAgent, "Chromium/"):
+		return "Chrome"
+	case strings.Contains(userAgent, "Safari/") && strings.Contains(userAgent, "Version/"):
+		return "Safari"
+	case strings.HasPrefix(userAgent, "curl/"):
+		return "curl"
+	case strings.HasPrefix(userAgent, "Go-http-client/"):
+		return "Go client"
+	}
+	return "Unknown browser"
+}
+
+// userAgentSystem checks Android before Linux and iOS before macOS,
+// since their user agents also mention the latter
+func userAgentSystem(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "Windows"):
+		return "Windows"
+	case strings.Contains(userAgent, "Android"):
+		return "Android"
+	case strings.Contains(userAgent, "iPhone"):
+		return "iPhone"
+	case strings.Contains(userAgent, "iPad"):
+		return "iPad"
+	case strings.Contains(userAgent, "CrOS"):
+		return "ChromeOS"
+	case strings.Contains(userAgent, "Mac OS X"), strings.Contains(userAgent, "Macintosh"):
+		return "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		return "Linux"
+	}
+	return ""
+}
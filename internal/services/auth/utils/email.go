@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrInvalidEmail is returned for addresses that are not syntactically valid
+var ErrInvalidEmail = errors.New("email is not a valid address")
+
+const (
+	maxEmailLength      = 254 // RFC 5321 path limit minus the angle brackets
+	maxEmailLocalLength = 64
+)
+
+// NormalizeEmail returns the canonical form of an email address, the form stored
+// and compared everywhere: surrounding spaces removed, the local part lowercased
+// (addresses are treated as case-insensitive) and the domain converted to lowercase
+// ASCII, internationalized domains to their punycode form (bücher.de -> xn--bcher-kva.de).
+//
+// Only the common dot-atom syntax is accepted: no quoted local parts, comments or
+// IP literals, and the local part must be ASCII (the mailer does not speak SMTPUTF8).
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 || at == len(email)-1 {
+		return "", ErrInvalidEmail
+	}
+	localPart, domain := email[:at], email[at+1:]
+
+	if !validLocalPart(localPart) {
+		return "", ErrInvalidEmail
+	}
+	asciiDomain, err := domainToASCII(domain)
+	if err != nil {
+		return "", err
+	}
+
+	normalized := strings.ToLower(localPart) + "@" + asciiDomain
+	if len(normalized) > maxEmailLength {
+		return "", ErrInvalidEmail
+	}
+	return normalized, nil
+}
+
+// validLocalPart accepts RFC 5322 dot-atoms: atext characters separated by single dots
+func validLocalPart(localPart string) bool {
+	if len(localPart) > maxEmailLocalLength {
+		return false
+	}
+	for _, atom := range strings.Split(localPart, ".") {
+		if atom == "" {
+			return false
+		}
+		for i := 0; i < len(atom); i++ {
+			if !isAtext(atom[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isAtext(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) >= 0
+}
+
+// mailDomainProfile converts domains with the UTS #46 lookup mapping (case folding, width
+// and full stop variants) and the IDNA2008 label rules: hyphens, joiners and bidi text are
+// checked, ASCII labels must be letters, digits and hyphens, labels and names fit in DNS
+var mailDomainProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.Transitional(false),
+	idna.StrictDomainName(true),
+	idna.VerifyDNSLength(true),
+)
+
+// domainToASCII validates a host name and returns its lowercase ASCII form,
+// internationalized labels punycode-encoded with the xn-- prefix
+func domainToASCII(domain string) (string, error) {
+	asciiDomain, err := mailDomainProfile.ToASCII(domain)
+	if err != nil {
+		return "", ErrInvalidEmail
+	}
+	labels := strings.Split(asciiDomain, ".")
+	// a mail domain needs a TLD, "user@localhost" is not accepted
+	if len(labels) < 2 {
+		return "", ErrInvalidEmail
+	}
+	// TLDs are never all-numeric, this also rejects IP addresses
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return "", ErrInvalidEmail
+	}
+	return asciiDomain, nil
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"Alice@Example.com", "alice@example.com"},
+		{"  bob.smith+tag@example.com ", "bob.smith+tag@example.com"},
+		// RFC 3492 / IDNA: internationalized domains are stored in punycode
+		{"user@bücher.de", "user@xn--bcher-kva.de"},
+		{"user@BÜCHER.DE", "user@xn--bcher-kva.de"},
+		{"user@xn--bcher-kva.de", "user@xn--bcher-kva.de"},
+		{"user@例え。テスト", "user@xn--r8jz45g.xn--zckzah"},
+		// UTS #46 mapping: ß is kept (nontransitional), compatibility forms are folded
+		{"user@faß.de", "user@xn--fa-hia.de"},
+		{"user@ﬁ.com", "user@fi.com"},
+	}
+	for _, test := range tests {
+		got, err := NormalizeEmail(test.email)
+		if err != nil {
+			t.Errorf("NormalizeEmail(%q) error: %v", test.email, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("NormalizeEmail(%q) = %q, want %q", test.email, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeEmailRejects(t *testing.T) {
+	tests := []string{
+		"",
+		"alice",
+		"alice@",
+		"@example.com",
+		"alice@localhost",
+		"alice@127.0.0.1",
+		"al..ice@example.com",
+		"\"alice\"@example.com",
+		"jörg@example.com",
+		"alice@exa mple.com",
+		"alice@a_b.com",
+		"alice@a..com",
+		// hyphens at the start or end, and at positions 3-4 of a label, are reserved
+		"alice@-example.com",
+		"alice@example-.com",
+		"alice@ab--cd.com",
+		// an xn-- label must be valid punycode
+		"alice@xn--zz.com",
+		// a label cannot start with a combining mark, a joiner needs a virama before it
+		"alice@\u0301a.com",
+		"alice@a\u200db.com",
+		// bidi rule: a label with right-to-left digits cannot mix in left-to-right letters
+		"alice@١٢٣abc.com",
+	}
+	for _, email := range tests {
+		if got, err := NormalizeEmail(email); !errors.Is(err, ErrInvalidEmail) {
+			t.Errorf("NormalizeEmail(%q) = %q, %v, want ErrInvalidEmail", email, got, err)
+		}
+	}
+}
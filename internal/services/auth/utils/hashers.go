@@ -0,0 +1,317 @@
+package utils
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Stored hash schemes, the part of a hash before the first "$"
+const (
+	SchemeArgon2id = "argon2id"
+	SchemeBcrypt   = "bcrypt"
+	SchemePBKDF2   = "pbkdf2"
+	SchemeScrypt   = "scrypt"
+)
+
+// upper bounds for the cost parameters of imported hashes: a stored hash decides
+// how much work a login does, so a bad import must not be able to stall the hash pool.
+// Scrypt memory is also capped at the Argon2 memory cost (see checkScryptMemory).
+const (
+	maxPBKDF2Iterations = 10_000_000
+	maxBcryptCost       = 14
+	maxScryptN          = 1 << 20
+	maxScryptR          = 32
+	maxScryptP          = 16
+)
+
+var errInvalidHash = errors.New("invalid password hash format")
+
+// PasswordHasher verifies the stored hashes of one scheme. Only Argon2id hashes are
+// created (HashPassword), the other schemes exist to verify imported legacy hashes,
+// which are replaced with Argon2id on the first successful login (see NeedsRehash).
+type PasswordHasher interface {
+	// Verify reports whether password matches hashedPassword
+	Verify(password string, hashedPassword string) (bool, error)
+	// Check reports whether hashedPassword is well-formed, without a password
+	Check(hashedPassword string) error
+}
+
+// passwordHashers maps a scheme to its hasher. Like the peppers it is only changed
+// at startup (RegisterPasswordHasher) and read afterwards.
+var passwordHashers = map[string]PasswordHasher{
+	SchemeArgon2id: argon2Hasher{},
+	SchemeBcrypt:   bcryptHasher{},
+	SchemePBKDF2:   pbkdf2Hasher{},
+	SchemeScrypt:   scryptHasher{},
+}
+
+// RegisterPasswordHasher adds support for another stored hash scheme,
+// hashes of it must look like "<scheme>$...". Call it at startup only.
+func RegisterPasswordHasher(scheme string, hasher PasswordHasher) error {
+	if scheme == "" || strings.Contains(scheme, "$") {
+		return fmt.Errorf("invalid hash scheme %q", scheme)
+	}
+	if hasher == nil {
+		return fmt.Errorf("hasher for %q is nil", scheme)
+	}
+	if _, duplicate := passwordHashers[scheme]; duplicate {
+		return fmt.Errorf("hash scheme %q is already registered", scheme)
+	}
+	passwordHashers[scheme] = hasher
+	return nil
+}
+
+// HashScheme returns the scheme of a stored hash, "" when it has none
+func HashScheme(hashedPassword string) string {
+	scheme, _, found := strings.Cut(hashedPassword, "$")
+	if !found {
+		return ""
+	}
+	return scheme
+}
+
+func hasherFor(hashedPassword string) (PasswordHasher, error) {
+	hasher, ok := passwordHashers[HashScheme(hashedPassword)]
+	if !ok {
+		return nil, errInvalidHash
+	}
+	return hasher, nil
+}
+
+// ImportPasswordHash converts a hash exported from another system into the stored form
+// and checks that it can be verified. Accepted input:
+//
+//	bcrypt$..., pbkdf2$..., scrypt$...     already in the stored form
+//	$2a$10$..., $2b$..., $2y$...            plain bcrypt (modular crypt format)
+//	pbkdf2_sha256$<iterations>$<salt>$<hash> Django style PBKDF2 (also pbkdf2_sha1, pbkdf2_sha512)
+//
+// Argon2id hashes cannot be imported: hashes of this scheme are expected to carry our pepper.
+func ImportPasswordHash(legacyHash string) (string, error) {
+	legacyHash = strings.TrimSpace(legacyHash)
+	storedHash := legacyHash
+	switch {
+	case strings.HasPrefix(legacyHash, "$2a$"), strings.HasPrefix(legacyHash, "$2b$"), strings.HasPrefix(legacyHash, "$2y$"):
+		storedHash = SchemeBcrypt + "$" + legacyHash
+	case strings.HasPrefix(legacyHash, "pbkdf2_"):
+		converted, err := convertDjangoPBKDF2(legacyHash)
+		if err != nil {
+			return "", err
+		}
+		storedHash = converted
+	case HashScheme(legacyHash) == SchemeArgon2id:
+		return "", errors.New("argon2id hashes from other systems are not supported")
+	}
+
+	hasher, err := hasherFor(storedHash)
+	if err != nil {
+		return "", errors.New("unsupported password hash format")
+	}
+	if err := hasher.Check(storedHash); err != nil {
+		return "", err
+	}
+	return storedHash, nil
+}
+
+// bcryptHasher verifies "bcrypt$<modular crypt hash>", e.g. bcrypt$$2b$12$<salt+hash>.
+// bcrypt looks only at the first 72 bytes of a password, as the old system did.
+type bcryptHasher struct{}
+
+func (bcryptHasher) Verify(password string, hashedPassword string) (bool, error) {
+	if err := (bcryptHasher{}).Check(hashedPassword); err != nil {
+		return false, err
+	}
+	legacyHash := strings.TrimPrefix(hashedPassword, SchemeBcrypt+"$")
+	err := bcrypt.CompareHashAndPassword([]byte(legacyHash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (bcryptHasher) Check(hashedPassword string) error {
+	legacyHash := strings.TrimPrefix(hashedPassword, SchemeBcrypt+"$")
+	cost, err := bcrypt.Cost([]byte(legacyHash))
+	if err != nil {
+		return err
+	}
+	// every step doubles the work, cost 31 takes days to verify
+	if cost > maxBcryptCost {
+		return fmt.Errorf("bcrypt cost must be at most %d", maxBcryptCost)
+	}
+	return nil
+}
+
+// pbkdf2Hasher verifies "pbkdf2$h=<sha1|sha256|sha512>$i=<iterations>$<salt>$<hash>",
+// salt and hash in unpadded standard base64
+type pbkdf2Hasher struct{}
+
+type pbkdf2Hash struct {
+	digest     func() hash.Hash
+	iterations int
+	salt       []byte
+	hash       []byte
+}
+
+func (pbkdf2Hasher) Verify(password string, hashedPassword string) (bool, error) {
+	parsed, err := parsePBKDF2Hash(hashedPassword)
+	if err != nil {
+		return false, err
+	}
+	computed, err := pbkdf2.Key(parsed.digest, password, parsed.salt, parsed.iterations, len(parsed.hash))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(computed, parsed.hash) == 1, nil
+}
+
+func (pbkdf2Hasher) Check(hashedPassword string) error {
+	_, err := parsePBKDF2Hash(hashedPassword)
+	return err
+}
+
+func parsePBKDF2Hash(hashedPassword string) (*pbkdf2Hash, error) {
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 5 || parts[0] != SchemePBKDF2 {
+		return nil, errInvalidHash
+	}
+	var parsed pbkdf2Hash
+	digestName, found := strings.CutPrefix(parts[1], "h=")
+	if !found {
+		return nil, errInvalidHash
+	}
+	digest, err := pbkdf2Digest(digestName)
+	if err != nil {
+		return nil, err
+	}
+	parsed.digest = digest
+	if _, err := fmt.Sscanf(parts[2], "i=%d", &parsed.iterations); err != nil {
+		return nil, errInvalidHash
+	}
+	if parsed.iterations < 1 || parsed.iterations > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("pbkdf2 iterations must be between 1 and %d", maxPBKDF2Iterations)
+	}
+	if parsed.salt, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return nil, errInvalidHash
+	}
+	if parsed.hash, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(parsed.hash) == 0 {
+		return nil, errInvalidHash
+	}
+	return &parsed, nil
+}
+
+func pbkdf2Digest(name string) (func() hash.Hash, error) {
+	switch name {
+	case "sha1":
+		return sha1.New, nil
+	case "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported pbkdf2 digest %q", name)
+}
+
+// convertDjangoPBKDF2 turns pbkdf2_sha256$<iterations>$<salt>$<base64 hash> into the stored form,
+// the salt there is used as text, not decoded
+func convertDjangoPBKDF2(legacyHash string) (string, error) {
+	parts := strings.Split(legacyHash, "$")
+	if len(parts) != 4 {
+		return "", errInvalidHash
+	}
+	digestName := strings.TrimPrefix(parts[0], "pbkdf2_")
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", errInvalidHash
+	}
+	return fmt.Sprintf("%s$h=%s$i=%s$%s$%s",
+		SchemePBKDF2, digestName, parts[1],
+		base64.RawStdEncoding.EncodeToString([]byte(parts[2])),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+// scryptHasher verifies "scrypt$n=<N>$r=<r>$p=<p>$<salt>$<hash>",
+// salt and hash in unpadded standard base64
+type scryptHasher struct{}
+
+type scryptHash struct {
+	n, r, p int
+	salt    []byte
+	hash    []byte
+}
+
+func (scryptHasher) Verify(password string, hashedPassword string) (bool, error) {
+	parsed, err := parseScryptHash(hashedPassword)
+	if err != nil {
+		return false, err
+	}
+	computed, err := scrypt.Key([]byte(password), parsed.salt, parsed.n, parsed.r, parsed.p, len(parsed.hash))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(computed, parsed.hash) == 1, nil
+}
+
+func (scryptHasher) Check(hashedPassword string) error {
+	_, err := parseScryptHash(hashedPassword)
+	return err
+}
+
+// checkScryptMemory keeps a verification within the memory of one Argon2 hash (ARGON2_MEMORY_KB),
+// the budget every hash pool worker is sized for. 128·N·r bytes per lane, counted for every lane.
+func checkScryptMemory(n, r, p int) error {
+	limit := uint64(CurrentArgon2Params().MemoryKB) * 1024
+	if 128*uint64(n)*uint64(r)*uint64(p) > limit {
+		return fmt.Errorf("scrypt parameters need more than %d KiB of memory (ARGON2_MEMORY_KB)", limit/1024)
+	}
+	return nil
+}
+
+func parseScryptHash(hashedPassword string) (*scryptHash, error) {
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 || parts[0] != SchemeScrypt {
+		return nil, errInvalidHash
+	}
+	var parsed scryptHash
+	if _, err := fmt.Sscanf(parts[1], "n=%d", &parsed.n); err != nil {
+		return nil, errInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[2], "r=%d", &parsed.r); err != nil {
+		return nil, errInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "p=%d", &parsed.p); err != nil {
+		return nil, errInvalidHash
+	}
+	// N must be a power of two greater than 1
+	if parsed.n < 2 || parsed.n > maxScryptN || parsed.n&(parsed.n-1) != 0 {
+		return nil, fmt.Errorf("scrypt n must be a power of two up to %d", maxScryptN)
+	}
+	if parsed.r < 1 || parsed.r > maxScryptR || parsed.p < 1 || parsed.p > maxScryptP {
+		return nil, fmt.Errorf("scrypt r must be 1..%d and p 1..%d", maxScryptR, maxScryptP)
+	}
+	if err := checkScryptMemory(parsed.n, parsed.r, parsed.p); err != nil {
+		return nil, err
+	}
+	var err error
+	if parsed.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errInvalidHash
+	}
+	if parsed.hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(parsed.hash) == 0 {
+		return nil, errInvalidHash
+	}
+	return &parsed, nil
+}
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrHashPoolFull is returned when the hashing queue is full, the request should be
+// answered with 503 instead of piling up more 64 MiB Argon2 jobs
+var ErrHashPoolFull = errors.New("server is busy, try again later")
+
+// waitBuckets are the upper bounds of the queue wait time histogram
+var waitBuckets = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// HashPool runs Argon2 hashing on a fixed number of workers.
+// Each Argon2 call allocates MemoryKB of memory, so the number of workers
+// bounds the memory used for hashing, and the queue depth bounds how many
+// requests may wait for a worker before new ones are rejected.
+type HashPool struct {
+	jobs chan hashJob
+
+	mutex sync.Mutex
+	stats HashPoolStats
+}
+
+type hashJob struct {
+	ctx        context.Context
+	enqueuedAt time.Time
+	run        func()
+	done       chan struct{}
+}
+
+// HashPoolStats are counters for monitoring the pool
+type HashPoolStats struct {
+	Workers    int   `json:"workers"`
+	QueueDepth int   `json:"queue_depth"`
+	Queued     int   `json:"queued"`
+	Completed  int64 `json:"completed"`
+	// Rejected — queue was full (503)
+	Rejected int64 `json:"rejected"`
+	// Abandoned — the caller gave up (context canceled) before a worker picked the job
+	Abandoned int64 `json:"abandoned"`
+
+	// time jobs spent in the queue before a worker picked them up
+	TotalWaitMs float64 `json:"total_wait_ms"`
+	MaxWaitMs   float64 `json:"max_wait_ms"`
+	// WaitHistogram counts waits <=1ms, <=10ms, <=100ms, <=1s, >1s
+	WaitHistogram [5]int64 `json:"wait_histogram"`
+}
+
+// NewHashPool — factory function (constructor). Starts workers goroutines.
+func NewHashPool(workers int, queueDepth int) (*HashPool, error) {
+	if workers < 1 {
+		return nil, errors.New("hash pool needs at least one worker")
+	}
+	if queueDepth < 0 {
+		return nil, errors.New("hash pool queue depth cannot be negative")
+	}
+	pool := &HashPool{
+		jobs: make(chan hashJob, queueDepth),
+		stats: HashPoolStats{
+			Workers:    workers,
+			QueueDepth: queueDepth,
+		},
+	}
+	for i := 0; i < workers; i++ {
+		go pool.worker()
+	}
+	return pool, nil
+}
+
+// Hash is HashPassword on a pool worker
+func (pool *HashPool) Hash(ctx context.Context, password string) (string, error) {
+	var (
+		hash    string
+		hashErr error
+	)
+	err := pool.submit(ctx, func() {
+		hash, hashErr = HashPassword(password)
+	})
+	if err != nil {
+		return "", err
+	}
+	return hash, hashErr
+}
+
+// Verify is VerifyPassword on a pool worker
+func (pool *HashPool) Verify(ctx context.Context, password string, hashedPassword string) (bool, error) {
+	var (
+		ok        bool
+		verifyErr error
+	)
+	err := pool.submit(ctx, func() {
+		ok, verifyErr = VerifyPassword(password, hashedPassword)
+	})
+	if err != nil {
+		return false, err
+	}
+	return ok, verifyErr
+}
+
+// VerifyDummy is VerifyDummyPassword on a pool worker
+func (pool *HashPool) VerifyDummy(ctx context.Context, password string) error {
+	return pool.submit(ctx, func() {
+		VerifyDummyPassword(password)
+	})
+}
+
+// Stats returns a snapshot of the counters
+func (pool *HashPool) Stats() HashPoolStats {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	stats := pool.stats
+	stats.Queued = len(pool.jobs)
+	return stats
+}
+
+// submit queues run and waits until a worker has executed it,
+// or returns early when the queue is full or ctx is done
+func (pool *HashPool) submit(ctx context.Context, run func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	job := hashJob{
+		ctx:        ctx,
+		enqueuedAt: time.Now(),
+		run:        run,
+		done:       make(chan struct{}),
+	}
+
+	// non-blocking send: a full queue means load shedding, not waiting
+	select {
+	case pool.jobs <- job:
+	default:
+		pool.mutex.Lock()
+		pool.stats.Rejected++
+		pool.mutex.Unlock()
+		return ErrHashPoolFull
+	}
+
+	select {
+	case <-job.done:
+		return nil
+	case <-ctx.Done():
+		// the worker sees the canceled context and skips the job
+		return ctx.Err()
+	}
+}
+
+func (pool *HashPool) worker() {
+	for job := range pool.jobs {
+		wait := time.Since(job.enqueuedAt)
+		if job.ctx.Err() != nil {
+			pool.mutex.Lock()
+			pool.stats.Abandoned++
+			pool.mutex.Unlock()
+			continue
+		}
+
+		pool.recordWait(wait)
+		job.run()
+		close(job.done)
+
+		pool.mutex.Lock()
+		pool.stats.Completed++
+		pool.mutex.Unlock()
+	}
+}
+
+func (pool *HashPool) recordWait(wait time.Duration) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	waitMs := float64(wait) / float64(time.Millisecond)
+	pool.stats.TotalWaitMs += waitMs
+	if waitMs > pool.stats.MaxWaitMs {
+		pool.stats.MaxWaitMs = waitMs
+	}
+	bucket := len(waitBuckets)
+	for i, bound := range waitBuckets {
+		if wait <= bound {
+			bucket = i
+			break
+		}
+	}
+	pool.stats.WaitHistogram[bucket]++
+}
@@ -7,82 +7,79 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
 	"golang.org/x/crypto/argon2"
 )
 
-func HashPassword(inputPassword string, pepper string) (string, error) {
-	
+// argon2Hash is a parsed stored hash
+type argon2Hash struct {
+	pepperID string
+	version  int
+	params   Argon2Params
+	salt     []byte
+	hash     []byte
+}
+
+// HashPassword hashes with the current Argon2 parameters and the current pepper
+func HashPassword(inputPassword string) (string, error) {
+	params := CurrentArgon2Params()
+	pepperID := CurrentPepperID()
+
 	// Generate random salt
 
 	// make is used to create a byte slice of the specified length
-	salt := make([]byte, saltLen)
+	salt := make([]byte, params.SaltLen)
 	// rand.Read fills the slice with random bytes
 	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
 
-	if pepper == "" {
-		return "", errors.New("password pepper is not configured")
+	pepper, err := pepperByID(pepperID)
+	if err != nil {
+		return "", err
 	}
-	
+
 	pepperedPassword := inputPassword + pepper
 	// hash the password with Argon2id
 	// make a byte slice to hold the hash
-	hash := argon2.IDKey([]byte(pepperedPassword), salt, timeCost, memoryKB, threads, keyLen)
+	hash := argon2.IDKey([]byte(pepperedPassword), salt, params.TimeCost, params.MemoryKB, params.Threads, params.KeyLen)
 
 	// Encode salt+hash into a single string we can store in DB
 	// base64.RawStdEncoding is used to encode the byte slices to base64 strings
 	b64Salt := base64.RawStdEncoding.EncodeToString(salt)
 	b64Hash := base64.RawStdEncoding.EncodeToString(hash)
 
-	// Store all parameters inside the string so you can verify later even if params change
-	// Format: argon2id$v=19$t=2$m=65536$p=1$<salt>$<hash>
+	// Store all parameters inside the string so you can verify later even if params change,
+	// k is the ID of the pepper, so peppers can be rotated
+	// Format: argon2id$v=19$t=2$m=65536$p=1$k=2$<salt>$<hash>
 	passwordHash := fmt.Sprintf(
-		"argon2id$v=%d$t=%d$m=%d$p=%d$%s$%s",
-		argon2.Version, timeCost, memoryKB, threads, b64Salt, b64Hash,
+		"argon2id$v=%d$t=%d$m=%d$p=%d$k=%s$%s$%s",
+		argon2.Version, params.TimeCost, params.MemoryKB, params.Threads, pepperID, b64Salt, b64Hash,
 	)
 	return passwordHash, nil
 
 }
 
-
-func VerifyPassword(userPassword string, pepper string, hashedPassword string) (bool, error) {
-	fmt.Println(hashedPassword)
-	// expected format:
-	// argon2id$v=19$t=2$m=65536$p=1$<salt>$<hash>
-	parts := strings.Split(hashedPassword, "$")
-	if len(parts) != 7 {
-		return false, errors.New("invalid password hash format")
-	}
-
-	var (
-		timeCost uint32
-		memoryKB uint32
-		threads  uint8
-	)
-
-	// paste params from parts to separate variables
-	// fmt.Sscanf("id=10 name=alex", "id=%d name=%s", &a, &b)
-	// a == 10
-	// b == "alex"
-	if _, err := fmt.Sscanf(parts[2], "t=%d", &timeCost); err != nil {
-		return false, err
-	}
-	if _, err := fmt.Sscanf(parts[3], "m=%d", &memoryKB); err != nil {
-		return false, err
-	}
-	if _, err := fmt.Sscanf(parts[4], "p=%d", &threads); err != nil {
+// VerifyPassword checks the password against a stored hash of any registered scheme
+// (see RegisterPasswordHasher): Argon2id hashes made here or hashes imported from other systems
+func VerifyPassword(userPassword string, hashedPassword string) (bool, error) {
+	hasher, err := hasherFor(hashedPassword)
+	if err != nil {
 		return false, err
 	}
+	return hasher.Verify(userPassword, hashedPassword)
+}
 
-	// decode base64 salt and hash
-	salt, err := base64.RawStdEncoding.DecodeString(parts[5])
+// argon2Hasher verifies the hashes made by HashPassword, with the pepper recorded in the hash
+type argon2Hasher struct{}
+
+func (argon2Hasher) Verify(userPassword string, hashedPassword string) (bool, error) {
+	parsed, err := parseArgon2Hash(hashedPassword)
 	if err != nil {
 		return false, err
 	}
-
-	expectedHash, err := base64.RawStdEncoding.DecodeString(parts[6])
+	pepper, err := pepperByID(parsed.pepperID)
 	if err != nil {
 		return false, err
 	}
@@ -90,21 +87,133 @@ func VerifyPassword(userPassword string, pepper string, hashedPassword string) (
 	// hash input password + pepper
 	hash := argon2.IDKey(
 		[]byte(userPassword+pepper),
-		salt,
-		timeCost,
-		memoryKB,
-		threads,
-		uint32(len(expectedHash)),
+		parsed.salt,
+		parsed.params.TimeCost,
+		parsed.params.MemoryKB,
+		parsed.params.Threads,
+		uint32(len(parsed.hash)),
 	)
 
 	// constant-time compare
 	// to prevent timing attacks
-
-
-	if subtle.ConstantTimeCompare(hash, expectedHash) == 1 {
+	if subtle.ConstantTimeCompare(hash, parsed.hash) == 1 {
 		return true, nil
 	}
 
 	return false, nil
 }
 
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+	dummyHashErr  error
+)
+
+// VerifyDummyPassword does the same Argon2 work as VerifyPassword against a hash
+// that matches nothing. Call it when the user does not exist, so that path takes
+// as long as a wrong password and response times do not reveal registered emails.
+func VerifyDummyPassword(userPassword string) {
+	// created lazily: Argon2 params and peppers are configured at startup before first use
+	dummyHashOnce.Do(func() {
+		randomPassword := make([]byte, 32)
+		if _, err := rand.Read(randomPassword); err != nil {
+			dummyHashErr = err
+			return
+		}
+		dummyHash, dummyHashErr = HashPassword(string(randomPassword))
+	})
+	if dummyHashErr != nil {
+		return
+	}
+	VerifyPassword(userPassword, dummyHash)
+}
+
+func (argon2Hasher) Check(hashedPassword string) error {
+	_, err := parseArgon2Hash(hashedPassword)
+	return err
+}
+
+// NeedsRehash reports whether a stored hash was made with other parameters
+// or another pepper than the current ones, so it should be replaced after the next successful login.
+// Imported hashes of other schemes always need it: they move to Argon2id on the first login.
+func NeedsRehash(hashedPassword string) bool {
+	if HashScheme(hashedPassword) != SchemeArgon2id {
+		return true
+	}
+	parsed, err := parseArgon2Hash(hashedPassword)
+	if err != nil {
+		return true
+	}
+	current := CurrentArgon2Params()
+	return parsed.pepperID != CurrentPepperID() ||
+		parsed.version != argon2.Version ||
+		parsed.params.TimeCost != current.TimeCost ||
+		parsed.params.MemoryKB != current.MemoryKB ||
+		parsed.params.Threads != current.Threads ||
+		parsed.params.KeyLen != current.KeyLen ||
+		parsed.params.SaltLen != current.SaltLen
+}
+
+// PepperID returns the ID of the pepper a stored hash was made with
+func PepperID(hashedPassword string) (string, error) {
+	parsed, err := parseArgon2Hash(hashedPassword)
+	if err != nil {
+		return "", err
+	}
+	return parsed.pepperID, nil
+}
+
+func parseArgon2Hash(hashedPassword string) (*argon2Hash, error) {
+	// expected format:
+	// argon2id$v=19$t=2$m=65536$p=1$k=2$<salt>$<hash>
+	// or the legacy format without pepper ID:
+	// argon2id$v=19$t=2$m=65536$p=1$<salt>$<hash>
+	parts := strings.Split(hashedPassword, "$")
+	if (len(parts) != 7 && len(parts) != 8) || parts[0] != "argon2id" {
+		return nil, errors.New("invalid password hash format")
+	}
+
+	var parsed argon2Hash
+	parsed.pepperID = LegacyPepperID
+	if len(parts) == 8 {
+		id, found := strings.CutPrefix(parts[5], "k=")
+		if !found || id == "" {
+			return nil, errors.New("invalid password hash format")
+		}
+		parsed.pepperID = id
+		// drop the pepper part, the rest has the legacy layout
+		parts = append(parts[:5], parts[6:]...)
+	}
+
+	// paste params from parts to separate variables
+	// fmt.Sscanf("id=10 name=alex", "id=%d name=%s", &a, &b)
+	// a == 10
+	// b == "alex"
+	if _, err := fmt.Sscanf(parts[1], "v=%d", &parsed.version); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Sscanf(parts[2], "t=%d", &parsed.params.TimeCost); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d", &parsed.params.MemoryKB); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Sscanf(parts[4], "p=%d", &parsed.params.Threads); err != nil {
+		return nil, err
+	}
+
+	// decode base64 salt and hash
+	var err error
+	parsed.salt, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, err
+	}
+	parsed.hash, err = base64.RawStdEncoding.DecodeString(parts[6])
+	if err != nil {
+		return nil, err
+	}
+	parsed.params.SaltLen = uint32(len(parsed.salt))
+	parsed.params.KeyLen = uint32(len(parsed.hash))
+
+	return &parsed, nil
+}
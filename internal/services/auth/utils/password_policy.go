@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// PasswordPolicy are the rules for new passwords. Existing passwords are not
+// re-checked, users meet a stricter policy the next time they change their password.
+type PasswordPolicy struct {
+	MinLength int // in characters
+	// in characters, bounds the Argon2 input (and the request work) of a single password
+	MaxLength int
+	// passwords from a known breach, nil when no list is configured
+	Breached *BreachedPasswords
+}
+
+// DefaultPasswordPolicy returns the policy used when nothing is configured
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength: 8,
+		MaxLength: 128,
+	}
+}
+
+// maxPasswordInput is the longest password any login accepts, whatever the policy.
+// 4 bytes per character at most, a single password stays within a few KiB.
+const maxPasswordInput = 1024
+
+// passwordPolicy is set once at startup by ConfigurePasswordPolicy and only read afterwards
+var passwordPolicy = DefaultPasswordPolicy()
+
+// ConfigurePasswordPolicy sets the rules for new passwords, call it at startup before serving requests
+func ConfigurePasswordPolicy(policy PasswordPolicy) error {
+	if policy.MinLength < 1 {
+		return errors.New("password min length must be at least 1")
+	}
+	if policy.MaxLength < policy.MinLength {
+		return errors.New("password max length must not be less than the min length")
+	}
+	if policy.MaxLength > maxPasswordInput {
+		return fmt.Errorf("password max length must be at most %d", maxPasswordInput)
+	}
+	passwordPolicy = policy
+	return nil
+}
+
+// CurrentPasswordPolicy returns the rules for new passwords
+func CurrentPasswordPolicy() PasswordPolicy {
+	return passwordPolicy
+}
+
+// LoadPasswordPolicyFromEnv reads PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH and
+// BREACHED_PASSWORDS_FILE, unset variables keep their default value
+func LoadPasswordPolicyFromEnv() (PasswordPolicy, error) {
+	policy := DefaultPasswordPolicy()
+	if err := uintFromEnv("PASSWORD_MIN_LENGTH", 16, func(value uint64) { policy.MinLength = int(value) }); err != nil {
+		return policy, err
+	}
+	if err := uintFromEnv("PASSWORD_MAX_LENGTH", 16, func(value uint64) { policy.MaxLength = int(value) }); err != nil {
+		return policy, err
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := LoadBreachedPasswords(path)
+		if err != nil {
+			return policy, fmt.Errorf("invalid BREACHED_PASSWORDS_FILE: %w", err)
+		}
+		policy.Breached = breached
+	}
+	return policy, nil
+}
+
+// PasswordTooLong reports whether a login password is longer than any password can be,
+// such input is refused before hashing. It does not use the policy max length:
+// lowering it must not lock out users whose older password is longer.
+func PasswordTooLong(password string) bool {
+	return len(password) > maxPasswordInput && utf8.RuneCountInString(password) > maxPasswordInput
+}
+
+// checkPassword returns why a new password is not acceptable, or "" if it is.
+// email and name are the account's own, a password containing them is easy to guess.
+func checkPassword(password string, email string, name string) string {
+	policy := passwordPolicy
+	if strings.TrimSpace(password) == "" {
+		return "password cannot be empty"
+	}
+	length := utf8.RuneCountInString(password)
+	if length < policy.MinLength {
+		return fmt.Sprintf("password must be at least %d characters", policy.MinLength)
+	}
+	if length > policy.MaxLength {
+		return fmt.Sprintf("password must be at most %d characters", policy.MaxLength)
+	}
+
+	lowerPassword := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+	// very short parts would reject too many good passwords
+	if len(localPart) >= 3 && strings.Contains(lowerPassword, localPart) {
+		return "password must not contain your email"
+	}
+	for _, word := range strings.Fields(strings.ToLower(name)) {
+		if utf8.RuneCountInString(word) >= 3 && strings.Contains(lowerPassword, word) {
+			return "password must not contain your name"
+		}
+	}
+
+	if policy.Breached.Contains(password) {
+		return "this password appeared in a data breach, choose another one"
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LegacyPepperID is the pepper ID assumed for hashes created before pepper IDs
+// were stored in the hash string (the single PASSWORD_PEPPER of older versions)
+const LegacyPepperID = "1"
+
+// pepperRing holds all known peppers by ID and which one is used for new hashes.
+// It is set once at startup by ConfigurePeppers and only read afterwards.
+type pepperRing struct {
+	currentID string
+	peppers   map[string]string
+}
+
+var peppers pepperRing
+
+// ConfigurePeppers sets the known peppers (ID -> secret) and the ID used for new hashes.
+// Keep retired peppers here until PepperReport shows no user needs them anymore.
+func ConfigurePeppers(currentID string, secrets map[string]string) error {
+	if len(secrets) == 0 {
+		return errors.New("password pepper is not configured")
+	}
+	for id, secret := range secrets {
+		if id == "" || strings.ContainsAny(id, "$=,:") {
+			return fmt.Errorf("invalid pepper id %q", id)
+		}
+		if secret == "" {
+			return fmt.Errorf("pepper %q is empty", id)
+		}
+	}
+	if _, ok := secrets[currentID]; !ok {
+		return fmt.Errorf("current pepper %q is not in the list of peppers", currentID)
+	}
+
+	copied := make(map[string]string, len(secrets))
+	for id, secret := range secrets {
+		copied[id] = secret
+	}
+	peppers = pepperRing{
+		currentID: currentID,
+		peppers:   copied,
+	}
+	return nil
+}
+
+// CurrentPepperID returns the ID of the pepper used for new hashes
+func CurrentPepperID() string {
+	return peppers.currentID
+}
+
+// LoadPeppersFromEnv reads the pepper configuration:
+//
+//	PASSWORD_PEPPERS=2:new_secret,1:old_secret   all peppers as id:secret pairs
+//	PASSWORD_PEPPER_ID=2                         pepper for new hashes (default: first in the list)
+//
+// Without PASSWORD_PEPPERS the single PASSWORD_PEPPER is used with LegacyPepperID.
+func LoadPeppersFromEnv() (string, map[string]string, error) {
+	list := os.Getenv("PASSWORD_PEPPERS")
+	if list == "" {
+		return LegacyPepperID, map[string]string{LegacyPepperID: os.Getenv("PASSWORD_PEPPER")}, nil
+	}
+
+	secrets := make(map[string]string)
+	currentID := os.Getenv("PASSWORD_PEPPER_ID")
+	for _, pair := range strings.Split(list, ",") {
+		id, secret, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found {
+			return "", nil, errors.New("PASSWORD_PEPPERS must be a list of id:secret pairs")
+		}
+		if _, duplicate := secrets[id]; duplicate {
+			return "", nil, fmt.Errorf("pepper %q is listed twice", id)
+		}
+		secrets[id] = secret
+		if currentID == "" {
+			currentID = id
+		}
+	}
+	return currentID, secrets, nil
+}
+
+func pepperByID(id string) (string, error) {
+	secret, ok := peppers.peppers[id]
+	if !ok {
+		return "", fmt.Errorf("unknown password pepper %q", id)
+	}
+	return secret, nil
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidHandle is returned for @handles that do not follow the rules of NormalizeHandle
+var ErrInvalidHandle = errors.New("handle must be 3-30 letters, digits or underscores and start with a letter")
+
+const (
+	minHandleLength      = 3
+	maxHandleLength      = 30
+	maxDisplayNameLength = 64
+	maxBioLength         = 500
+	maxStatusTextLength  = 100
+	maxStatusEmojiLength = 10 // runes: flags, skin tones and ZWJ sequences take several
+	maxAvatarURLLength   = 2048
+)
+
+// runes that combine with an emoji symbol in one status emoji
+const (
+	zeroWidthJoiner = '\u200d'
+	firstSkinTone   = '\U0001F3FB'
+	lastSkinTone    = '\U0001F3FF'
+)
+
+// reservedHandles cannot be taken by users: they name the system or look like staff
+var reservedHandles = map[string]bool{
+	"admin": true, "administrator": true, "root": true, "system": true, "support": true,
+	"help": true, "security": true, "staff": true, "moderator": true, "official": true,
+	"messenger": true, "api": true, "me": true, "deleted": true, "erased": true,
+	"null": true, "undefined": true,
+}
+
+// NormalizeHandle returns the stored form of an @handle: surrounding spaces and the
+// leading "@" removed, lowercased. Handles are ASCII letters, digits and underscores,
+// start with a letter (so they never look like a user ID) and are unique case-insensitively.
+func NormalizeHandle(handle string) (string, error) {
+	handle = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(handle), "@"))
+	if len(handle) < minHandleLength || len(handle) > maxHandleLength {
+		return "", ErrInvalidHandle
+	}
+	for i, r := range handle {
+		switch {
+		case r >= 'a' && r <= 'z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '_'):
+		default:
+			return "", ErrInvalidHandle
+		}
+	}
+	if reservedHandles[handle] {
+		return "", fmt.Errorf("the handle @%s is reserved", handle)
+	}
+	return handle, nil
+}
+
+// checkHandle returns why a handle is not acceptable, or "" if it is.
+// An empty handle removes it from the profile.
+func checkHandle(handle string) string {
+	if strings.TrimSpace(handle) == "" {
+		return ""
+	}
+	if _, err := NormalizeHandle(handle); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// checkProfileText limits a text field by characters and rejects control characters,
+// newlines are allowed only where multiline is set (the bio)
+func checkProfileText(name string, text string, maxLength int, multiline bool) string {
+	if utf8.RuneCountInString(text) > maxLength {
+		return fmt.Sprintf("%s must be at most %d characters", name, maxLength)
+	}
+	for _, r := range text {
+		if r == '\n' && multiline {
+			continue
+		}
+		if unicode.IsControl(r) {
+			return fmt.Sprintf("%s cannot contain control characters", name)
+		}
+	}
+	return ""
+}
+
+// checkStatusEmoji accepts a single emoji, including flags, skin tones and ZWJ sequences
+func checkStatusEmoji(emoji string) string {
+	if emoji == "" {
+		return ""
+	}
+	if utf8.RuneCountInString(emoji) > maxStatusEmojiLength {
+		return "status_emoji must be a single emoji"
+	}
+	hasSymbol := false
+	for _, r := range emoji {
+		switch {
+		case unicode.Is(unicode.So, r):
+			hasSymbol = true
+		// variation selectors, skin tones and joiners change or combine the symbols
+		case unicode.In(r, unicode.Mn, unicode.Me), r == zeroWidthJoiner, r >= firstSkinTone && r <= lastSkinTone:
+		default:
+			return "status_emoji must be a single emoji"
+		}
+	}
+	if !hasSymbol {
+		return "status_emoji must be a single emoji"
+	}
+	return ""
+}
+
+// checkAvatarURL accepts an absolute http(s) URL without credentials
+func checkAvatarURL(avatarURL string) string {
+	if avatarURL == "" {
+		return ""
+	}
+	if len(avatarURL) > maxAvatarURLLength {
+		return fmt.Sprintf("avatar_url must be at most %d characters", maxAvatarURLLength)
+	}
+	parsed, err := url.Parse(avatarURL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" || parsed.User != nil {
+		return "avatar_url must be an http or https URL"
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"lesson-proj/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenManager signs and validates access tokens (JWT, HMAC-SHA256)
+// and issues opaque refresh tokens
+type TokenManager struct {
+	secret     []byte
+	ttl        time.Duration
+	refreshTTL time.Duration
+}
+
+// NewTokenManager — factory function (constructor).
+// secret is the HMAC key, ttl is how long an access token stays valid,
+// refreshTTL is how long a refresh token stays valid.
+func NewTokenManager(secret string, ttl time.Duration, refreshTTL time.Duration) (*TokenManager, error) {
+	if secret == "" {
+		return nil, errors.New("access token secret is not configured")
+	}
+	if ttl <= 0 || refreshTTL <= 0 {
+		return nil, errors.New("token ttl must be positive")
+	}
+	return &TokenManager{
+		secret:     []byte(secret),
+		ttl:        ttl,
+		refreshTTL: refreshTTL,
+	}, nil
+}
+
+// accessClaims are the JWT claims of an access token. iat has whole seconds only, iat_us
+// is the issue time in microseconds (the precision of Postgres timestamps), so a token
+// issued in the same second as a session revocation but before it is still rejected.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	IssuedAtMicro int64 `json:"iat_us"`
+}
+
+// GenerateAccessToken returns a signed token for userID and its expiry time
+func (manager *TokenManager) GenerateAccessToken(userID int) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(manager.ttl)
+
+	// sub — who the token belongs to, iat/exp — when it was issued / expires
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(userID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		IssuedAtMicro: now.UnixMicro(),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	signedToken, err := token.SignedString(manager.secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signedToken, expiresAt, nil
+}
+
+// AccessTokenClaims is the data carried by a valid access token
+type AccessTokenClaims struct {
+	UserID int
+	// microsecond precision
+	IssuedAt time.Time
+}
+
+// ParseAccessToken checks signature and expiry and returns the claims of the token
+func (manager *TokenManager) ParseAccessToken(tokenString string) (*AccessTokenClaims, error) {
+	var claims accessClaims
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) {
+			return manager.secret, nil
+		},
+		// only accept HS256, so a token with "alg": "none" or RS256 is rejected
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, errors.New("invalid token subject")
+	}
+	// tokens issued before iat_us existed have to be refreshed
+	if claims.IssuedAt == nil || claims.IssuedAtMicro <= 0 {
+		return nil, errors.New("token has no issue time")
+	}
+	return &AccessTokenClaims{
+		UserID:   userID,
+		IssuedAt: time.UnixMicro(claims.IssuedAtMicro),
+	}, nil
+}
+
+// GenerateRefreshToken returns a new refresh token, the hash to store in the DB and its expiry time
+func (manager *TokenManager) GenerateRefreshToken() (string, string, time.Time, error) {
+	token, err := GenerateRandomToken()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return token, HashToken(token), time.Now().Add(manager.refreshTTL), nil
+}
+
+// GenerateRandomToken returns 32 random bytes encoded as URL-safe base64
+func GenerateRandomToken() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// HashToken returns the hex SHA-256 of a random token.
+// A fast hash is fine here (unlike passwords): the token has 256 bits of entropy.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAPIKey returns a new key "mk_<prefix>_<secret>" and its prefix.
+// The prefix (8 hex chars) is stored in plain form to find the key, the whole key only as a hash.
+func GenerateAPIKey() (key string, prefix string, err error) {
+	prefixBytes := make([]byte, 4)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", err
+	}
+	secret, err := GenerateRandomToken()
+	if err != nil {
+		return "", "", err
+	}
+	prefix = hex.EncodeToString(prefixBytes)
+	return models.APIKeyPrefix + prefix + "_" + secret, prefix, nil
+}
+
+// ParseAPIKeyPrefix returns the prefix of a key in the GenerateAPIKey format
+func ParseAPIKeyPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, models.APIKeyPrefix)
+	if !ok || len(rest) < 10 || rest[8] != '_' {
+		return "", false
+	}
+	return rest[:8], true
+}
@@ -2,32 +2,169 @@ package utils
 
 import (
 	"errors"
+	"fmt"
+	"lesson-proj/internal/models"
+	"sort"
 	"strings"
+	"time"
 )
 
-func ValidateUpdateUserInput(email *string, name *string, password *string) error {
-	if email != nil && strings.TrimSpace(*email) == "" {
-		return errors.New("email cannot be empty")
+// ValidationError lists the invalid fields of an input, JSON field name -> message
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (validationError *ValidationError) Error() string {
+	names := make([]string, 0, len(validationError.Fields))
+	for name := range validationError.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	messages := make([]string, 0, len(names))
+	for _, name := range names {
+		messages = append(messages, validationError.Fields[name])
+	}
+	return strings.Join(messages, "; ")
+}
+
+// add keeps the first message of a field, an empty message is no error
+func (validationError *ValidationError) add(field string, message string) {
+	if message == "" {
+		return
+	}
+	if validationError.Fields == nil {
+		validationError.Fields = make(map[string]string)
+	}
+	if _, exists := validationError.Fields[field]; !exists {
+		validationError.Fields[field] = message
+	}
+}
+
+// err returns nil when no field is invalid
+func (validationError *ValidationError) err() error {
+	if len(validationError.Fields) == 0 {
+		return nil
+	}
+	return validationError
+}
+
+// ValidateUpdateUserInput checks the profile fields of an update, a new password
+// is checked separately with ValidateNewPassword
+func ValidateUpdateUserInput(email *string, name *string) error {
+	var validationError ValidationError
+	if email != nil {
+		validationError.add("email", checkEmail(*email))
 	}
 	if name != nil && strings.TrimSpace(*name) == "" {
-		return errors.New("name cannot be empty")
+		validationError.add("name", "name cannot be empty")
 	}
-	if password != nil && strings.TrimSpace(*password) == "" {
-		return errors.New("password cannot be empty")
+	return validationError.err()
+}
+
+// ValidateProfileInput checks the fields of a profile update, empty strings remove a field
+func ValidateProfileInput(input models.UpdateProfile) error {
+	var validationError ValidationError
+	if input.DisplayName != nil {
+		validationError.add("display_name", checkProfileText("display_name", *input.DisplayName, maxDisplayNameLength, false))
 	}
-	return nil
+	if input.Handle != nil {
+		validationError.add("handle", checkHandle(*input.Handle))
+	}
+	if input.Bio != nil {
+		validationError.add("bio", checkProfileText("bio", *input.Bio, maxBioLength, true))
+	}
+	if input.AvatarURL != nil {
+		validationError.add("avatar_url", checkAvatarURL(*input.AvatarURL))
+	}
+	if input.StatusText != nil {
+		validationError.add("status_text", checkProfileText("status_text", *input.StatusText, maxStatusTextLength, false))
+	}
+	if input.StatusEmoji != nil {
+		validationError.add("status_emoji", checkStatusEmoji(*input.StatusEmoji))
+	}
+	return validationError.err()
 }
 
+// ValidateCreateUserInput checks a registration and reports every invalid field
 func ValidateCreateUserInput(email string, name string, password string) error {
-	if strings.TrimSpace(email) == "" {
-		return errors.New("email cannot be empty")
+	var validationError ValidationError
+	validationError.add("email", checkEmail(email))
+	if strings.TrimSpace(name) == "" {
+		validationError.add("name", "name cannot be empty")
 	}
+	validationError.add("password", checkPassword(password, email, name))
+	return validationError.err()
+}
+
+// ValidateNewPassword checks a password against the policy (see PasswordPolicy).
+// field is the JSON field it came from, email and name belong to the account.
+func ValidateNewPassword(field string, password string, email string, name string) error {
+	var validationError ValidationError
+	validationError.add(field, checkPassword(password, email, name))
+	return validationError.err()
+}
+
+// ValidateImportedUser checks an account imported by the admin CLI,
+// passwordHash is the hash from the old system (see ImportPasswordHash)
+func ValidateImportedUser(email string, name string, passwordHash string) error {
+	var validationError ValidationError
+	validationError.add("email", checkEmail(email))
 	if strings.TrimSpace(name) == "" {
+		validationError.add("name", "name cannot be empty")
+	}
+	if _, err := ImportPasswordHash(passwordHash); err != nil {
+		validationError.add("password_hash", err.Error())
+	}
+	return validationError.err()
+}
+
+// ValidateEmail checks an email sent in the given JSON field
+func ValidateEmail(field string, email string) error {
+	var validationError ValidationError
+	validationError.add(field, checkEmail(email))
+	return validationError.err()
+}
+
+// checkEmail returns why an email is not acceptable, or "" if it is
+func checkEmail(email string) string {
+	if strings.TrimSpace(email) == "" {
+		return "email cannot be empty"
+	}
+	if _, err := NormalizeEmail(email); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func ValidateRole(role string) error {
+	switch role {
+	case models.RoleUser, models.RoleModerator, models.RoleAdmin:
+		return nil
+	}
+	return errors.New("role must be one of: user, moderator, admin")
+}
+
+// ValidateAPIKeyInput checks the name, scopes and expiry of a new API key
+func ValidateAPIKeyInput(name string, scopes []string, expiresAt *time.Time) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
 		return errors.New("name cannot be empty")
 	}
-	if strings.TrimSpace(password) == "" {
-		return errors.New("password cannot be empty")
+	if len(name) > 100 {
+		return errors.New("name must be at most 100 characters")
+	}
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case models.ScopeProductsRead, models.ScopeProductsWrite, models.ScopeMessagesSend:
+		default:
+			return fmt.Errorf("unknown scope %q, use: products:read, products:write, messages:send", scope)
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
 	}
 	return nil
 }
-
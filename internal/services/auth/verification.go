@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lesson-proj/internal/database"
+	"lesson-proj/internal/mailer"
+	"lesson-proj/internal/models"
+	authUtils "lesson-proj/internal/services/auth/utils"
+	"lesson-proj/internal/services/permissions"
+	"net/url"
+	"time"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+// VerifyEmail redeems a verification token and marks the email of its owner as verified
+func (service *UserService) VerifyEmail(ctx context.Context, token string) (err error) {
+	var userID int
+	defer func() { service.recordUserEvent(ctx, models.AuditUserEmailVerified, userID, err, nil) }()
+
+	if token == "" {
+		return ErrInvalidToken
+	}
+	consumed, err := service.oneTimeTokens.ConsumeToken(ctx, models.TokenPurposeEmailVerification, authUtils.HashToken(token))
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	userID = consumed.UserID
+	return service.repository.MarkEmailVerified(ctx, consumed.UserID)
+}
+
+// ResendVerification sends a fresh verification link to the caller,
+// links sent earlier stop working
+func (service *UserService) ResendVerification(ctx context.Context) error {
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return err
+	}
+	user, err := service.repository.GetUserByID(ctx, caller.UserID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+	return service.sendVerificationEmail(ctx, *user)
+}
+
+func (service *UserService) sendVerificationEmail(ctx context.Context, user models.UserWithoutPassword) error {
+	if err := service.oneTimeTokens.InvalidateUserTokens(ctx, user.ID, models.TokenPurposeEmailVerification); err != nil {
+		return err
+	}
+	token, err := service.issueOneTimeToken(ctx, user.ID, models.TokenPurposeEmailVerification, "", emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	link := service.appBaseURL + "/verify-email?token=" + url.QueryEscape(token)
+	return service.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nconfirm your email address by opening this link:\n%s\n\nThe link is valid for 24 hours.",
+			user.Name, link,
+		),
+	})
+}
+
+// sendAlreadyRegisteredEmail tells the owner of an address that someone tried
+// to register it again (a registration attempt never reveals this to the caller)
+func (service *UserService) sendAlreadyRegisteredEmail(ctx context.Context, email string) error {
+	return service.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "You already have an account",
+		Body: fmt.Sprintf(
+			"Someone tried to create an account with this email address, but you already have one.\n\n"+
+				"If it was you, log in or reset your password here:\n%s\n\nIf it was not you, ignore this email.",
+			service.appBaseURL+"/forgot-password",
+		),
+	})
+}
+
+// issueOneTimeToken stores the hash of a new single-use token and returns the token itself
+func (service *UserService) issueOneTimeToken(ctx context.Context, userID int, purpose string, payload string, ttl time.Duration) (string, error) {
+	token, err := authUtils.GenerateRandomToken()
+	if err != nil {
+		return "", err
+	}
+	err = service.oneTimeTokens.CreateToken(ctx, models.OneTimeToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: authUtils.HashToken(token),
+		Payload:   payload,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
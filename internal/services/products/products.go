@@ -4,21 +4,35 @@ import (
 	"context"
 	"lesson-proj/internal/database"
 	"lesson-proj/internal/models"
+	auditService "lesson-proj/internal/services/audit"
+	"lesson-proj/internal/services/permissions"
 	productUtils "lesson-proj/internal/services/products/utils"
+	"time"
 )
 
 type ProductService struct {
 	repository *database.ProductRepository
+	audit      *auditService.AuditService
 }
 
-func NewProductService(repository *database.ProductRepository) *ProductService {
+func NewProductService(repository *database.ProductRepository, audit *auditService.AuditService) *ProductService {
 	return &ProductService{
 		repository: repository,
+		audit:      audit,
 	}
 }
 
-func (productService *ProductService) GetAllProducts(ctx context.Context) ([]models.Product, error) {
-	product, err := productService.repository.GetAllProducts(ctx)
+// GetAllProducts lists the products, deleted ones only for admins
+func (productService *ProductService) GetAllProducts(ctx context.Context, filter models.ProductFilter) ([]models.Product, error) {
+	if err := permissions.RequireScope(ctx, models.ScopeProductsRead); err != nil {
+		return nil, err
+	}
+	if filter.IncludeDeleted {
+		if err := permissions.RequireAdmin(ctx); err != nil {
+			return nil, err
+		}
+	}
+	product, err := productService.repository.GetAllProducts(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -26,6 +40,9 @@ func (productService *ProductService) GetAllProducts(ctx context.Context) ([]mod
 }
 
 func (productService *ProductService) GetProductByID(ctx context.Context, id int) (*models.Product, error) {
+	if err := permissions.RequireScope(ctx, models.ScopeProductsRead); err != nil {
+		return nil, err
+	}
 	product, err := productService.repository.GetProductByID(ctx, id)
 	if err != nil {
 		return nil, err
@@ -33,18 +50,46 @@ func (productService *ProductService) GetProductByID(ctx context.Context, id int
 	return product, nil
 }
 
-func (productService *ProductService) CreateProduct(ctx context.Context, inputProduct models.CreateProduct) (*models.Product, error) {
+// CreateProduct creates a listing owned by the authenticated caller,
+// the caller must have a verified email
+func (productService *ProductService) CreateProduct(ctx context.Context, inputProduct models.CreateProduct) (createdProduct *models.Product, err error) {
+	defer func() {
+		productID := 0
+		if createdProduct != nil {
+			productID = createdProduct.ID
+		}
+		productService.recordProductEvent(ctx, models.AuditProductCreated, productID, err)
+	}()
+
+	caller, err := permissions.Caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := permissions.RequireScope(ctx, models.ScopeProductsWrite); err != nil {
+		return nil, err
+	}
+	if err := permissions.RequireVerifiedEmail(ctx); err != nil {
+		return nil, err
+	}
+	inputProduct.SellerID = caller.UserID
+
 	if err := productUtils.ValidateCreateProductInput(inputProduct.Title, inputProduct.Description, inputProduct.Price); err != nil {
 		return nil, err
 	}
-	createdProduct, err := productService.repository.CreateProduct(ctx, inputProduct)
+	createdProduct, err = productService.repository.CreateProduct(ctx, inputProduct)
 	if err != nil {
 		return nil, err
 	}
 	return createdProduct, nil
 }
 
-func (productService *ProductService) UpdateProduct(ctx context.Context, id int, inputProduct models.UpdateProduct) (*models.Product, error) {
+// UpdateProduct is allowed for the seller of the product and admins
+func (productService *ProductService) UpdateProduct(ctx context.Context, id int, inputProduct models.UpdateProduct) (updatedProduct *models.Product, err error) {
+	defer func() { productService.recordProductEvent(ctx, models.AuditProductUpdated, id, err) }()
+
+	if err := productService.requireSellerOrAdmin(ctx, id); err != nil {
+		return nil, err
+	}
 	if err := productUtils.ValidateUpdateProductInput(
 		inputProduct.Title, 
 		inputProduct.Description, 
@@ -52,17 +97,82 @@ func (productService *ProductService) UpdateProduct(ctx context.Context, id int,
 	); err != nil {
 		return nil, err
 	}
-	updatedProduct, err := productService.repository.UpdateProduct(ctx, id, inputProduct)
+	updatedProduct, err = productService.repository.UpdateProduct(ctx, id, inputProduct)
 	if err != nil {
 		return nil, err
 	}
 	return updatedProduct, nil
 }
 
-func (productService *ProductService) DeleteProduct(ctx context.Context, id int) error {
-	err := productService.repository.DeleteProduct(ctx, id)
+// DeleteProduct is allowed for the seller of the product and admins.
+// The product is only hidden, admins can restore it until the retention job purges it.
+func (productService *ProductService) DeleteProduct(ctx context.Context, id int) (err error) {
+	defer func() { productService.recordProductEvent(ctx, models.AuditProductDeleted, id, err) }()
+
+	if err := productService.requireSellerOrAdmin(ctx, id); err != nil {
+		return err
+	}
+	err = productService.repository.DeleteProduct(ctx, id)
 	if err != nil {
 		return err
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// RestoreProduct brings back a deleted product, admins only
+func (productService *ProductService) RestoreProduct(ctx context.Context, id int) (restoredProduct *models.Product, err error) {
+	defer func() { productService.recordProductEvent(ctx, models.AuditProductRestored, id, err) }()
+
+	if err := permissions.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := permissions.RequireScope(ctx, models.ScopeProductsWrite); err != nil {
+		return nil, err
+	}
+	restoredProduct, err = productService.repository.RestoreProduct(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return restoredProduct, nil
+}
+
+// PurgeDeletedProducts removes the products deleted more than retention ago,
+// run by the retention job. Returns how many were removed.
+func (productService *ProductService) PurgeDeletedProducts(ctx context.Context, retention time.Duration) (int64, error) {
+	purged, err := productService.repository.PurgeDeletedProducts(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	if purged > 0 {
+		productService.audit.Record(ctx, models.AuditEvent{
+			Type:      models.AuditProductsPurged,
+			UserAgent: "retention job",
+			Details:   map[string]any{"count": purged, "retention": retention.String()},
+		}, nil)
+	}
+	return purged, nil
+}
+
+func (productService *ProductService) requireSellerOrAdmin(ctx context.Context, id int) error {
+	if _, err := permissions.Caller(ctx); err != nil {
+		return err
+	}
+	if err := permissions.RequireScope(ctx, models.ScopeProductsWrite); err != nil {
+		return err
+	}
+	product, err := productService.repository.GetProductByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	return permissions.RequireOwnerOrAdmin(ctx, product.SellerID)
+}
+
+// recordProductEvent audits a product write, productID 0 when no product was created
+func (productService *ProductService) recordProductEvent(ctx context.Context, eventType string, productID int, err error) {
+	event := models.AuditEvent{Type: eventType}
+	if productID != 0 {
+		event.TargetType = models.AuditTargetProduct
+		event.TargetID = &productID
+	}
+	productService.audit.Record(ctx, event, err)
+}
@@ -1,22 +1,223 @@
 -- Drop an existing table 'TableName'
+DROP TABLE IF EXISTS audit_events;
+DROP FUNCTION IF EXISTS audit_events_append_only;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS oidc_login_states;
+DROP TABLE IF EXISTS identities;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS logins;
+DROP TABLE IF EXISTS one_time_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
 DROP TABLE IF EXISTS products;
 DROP TABLE IF EXISTS users;
 
 
+CREATE TABLE users (
+    id SERIAL PRIMARY KEY,
+    -- normalized by the app (lowercase, punycode domain), unique case-insensitively below
+    email VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    hashed_password VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin')),
+    -- NULL until the user opens the verification link
+    email_verified_at TIMESTAMPTZ,
+    -- access tokens issued before this moment are rejected
+    sessions_revoked_at TIMESTAMPTZ,
+    -- TOTP secret encrypted with TOTP_ENCRYPTION_KEY, enforced once totp_enabled_at is set
+    totp_secret TEXT,
+    totp_enabled_at TIMESTAMPTZ,
+    -- last accepted TOTP time step, stops a code from being replayed
+    totp_last_step BIGINT,
+    -- public profile, NULL when not set; the handle is stored lowercase without the "@"
+    display_name VARCHAR(64),
+    handle VARCHAR(30),
+    bio VARCHAR(500),
+    avatar_url VARCHAR(2048),
+    status_text VARCHAR(100),
+    status_emoji VARCHAR(64),
+    -- updated at most once a minute by signed-in requests
+    last_seen_at TIMESTAMPTZ,
+    -- suspended by an admin: hidden and cannot sign in until restored, never purged
+    deactivated_at TIMESTAMPTZ,
+    -- soft deleted (DELETE /users/{id}): hidden and cannot sign in until restored
+    deleted_at TIMESTAMPTZ,
+    -- set together with deleted_at, the erasure job completes the deletion after this moment
+    erasure_scheduled_for TIMESTAMPTZ,
+    -- the account was erased: the row is kept pseudonymized for the content that refers to it
+    erased_at TIMESTAMPTZ
+);
+CREATE INDEX idx_users_erasure_scheduled_for ON users (erasure_scheduled_for) WHERE erasure_scheduled_for IS NOT NULL;
+CREATE UNIQUE INDEX idx_users_email_lower ON users (LOWER(email));
+CREATE UNIQUE INDEX idx_users_handle_lower ON users (LOWER(handle));
+
+-- seller_id: the user who created the listing. Erased accounts keep their row
+-- (pseudonymized), so their listings stay and show "Deleted user". Listings of deleted
+-- accounts are hidden only until the erasure, and those of deactivated accounts until restored.
 CREATE TABLE products (
     id SERIAL PRIMARY KEY,
     title VARCHAR(255) NOT NULL,
     description TEXT,
     price INT,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-    
+    seller_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    -- soft deleted: hidden until restored, the retention job removes it after DELETED_PRODUCT_RETENTION
+    deleted_at TIMESTAMPTZ
 );
-CREATE TABLE users (
+CREATE INDEX idx_products_seller_id ON products (seller_id);
+CREATE INDEX idx_products_deleted_at ON products (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Refresh tokens: only the SHA-256 hash is stored.
+-- family_id groups the tokens of one login, rotation keeps the family,
+-- reuse of a rotated token revokes the whole family.
+CREATE TABLE refresh_tokens (
     id SERIAL PRIMARY KEY,
-    email VARCHAR(255) NOT NULL Unique,
-    name VARCHAR(255) NOT NULL,
-    hashed_password VARCHAR(255) NOT NULL
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id VARCHAR(64) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    rotated_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+-- Successful logins (login history), each one starts the refresh token family family_id
+CREATE TABLE logins (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id VARCHAR(64) NOT NULL,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    -- derived from the user agent ("Firefox on Linux")
+    device_label VARCHAR(64) NOT NULL,
+    -- client network (IPv4 /24, IPv6 /48), a label not seen from this network means a new device
+    network VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_logins_user_id ON logins (user_id, id);
+CREATE INDEX idx_logins_family_id ON logins (family_id);
+
+-- Single-use tokens sent by email (email verification, ...), only the SHA-256 hash is stored
+CREATE TABLE one_time_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(32) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    payload TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 );
+CREATE INDEX idx_one_time_tokens_user_purpose ON one_time_tokens (user_id, purpose);
 
+-- Failed login counters per account (normalized email) and per IP, with temporary lockouts
+CREATE TABLE login_attempts (
+    scope VARCHAR(16) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ,
+    PRIMARY KEY (scope, key)
+);
+
+-- 2FA recovery codes, only the SHA-256 hash is stored, each code works once
+CREATE TABLE recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+-- External OIDC accounts linked to users: one per provider per user,
+-- a provider account (provider + subject) belongs to exactly one user
+CREATE TABLE identities (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMPTZ,
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+-- OIDC logins between the redirect to the provider and the callback (state, nonce, PKCE verifier)
+CREATE TABLE oidc_login_states (
+    state_hash CHAR(64) PRIMARY KEY,
+    provider VARCHAR(64) NOT NULL,
+    nonce VARCHAR(128) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    -- SHA-256 of the device token held by the client that started the login (login CSRF)
+    device_hash CHAR(64) NOT NULL,
+    link_user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- Personal API keys: the key is shown once, only its SHA-256 hash is stored.
+-- prefix is the visible part of the key used to look it up and to show it in lists.
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL UNIQUE,
+    key_hash CHAR(64) NOT NULL,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMPTZ
+);
+CREATE INDEX idx_api_keys_user_id ON api_keys (user_id);
+
+-- Security audit log. No foreign keys: events must outlive the users and products they mention.
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    event_type VARCHAR(64) NOT NULL,
+    outcome VARCHAR(16) NOT NULL CHECK (outcome IN ('success', 'failure', 'denied')),
+    actor_id INT,
+    actor_api_key_id INT,
+    target_type VARCHAR(32),
+    target_id INT,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    details JSONB
+);
+CREATE INDEX idx_audit_events_actor_id ON audit_events (actor_id, id);
+CREATE INDEX idx_audit_events_target ON audit_events (target_type, target_id, id);
+CREATE INDEX idx_audit_events_type ON audit_events (event_type, id);
+CREATE INDEX idx_audit_events_occurred_at ON audit_events (occurred_at);
 
+-- append-only: rows can be inserted, never changed or deleted.
+-- The one exception is account erasure: with app.audit_pseudonymize set for its transaction
+-- it may clear personal data (IP, user agent, details), never what happened, when or to whom.
+-- This guards against mistakes in the app, not against someone with database access.
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND current_setting('app.audit_pseudonymize', true) = 'on'
+        AND NEW.id = OLD.id
+        AND NEW.occurred_at = OLD.occurred_at
+        AND NEW.event_type = OLD.event_type
+        AND NEW.outcome = OLD.outcome
+        AND NEW.actor_id IS NOT DISTINCT FROM OLD.actor_id
+        AND NEW.actor_api_key_id IS NOT DISTINCT FROM OLD.actor_api_key_id
+        AND NEW.target_type IS NOT DISTINCT FROM OLD.target_type
+        AND NEW.target_id IS NOT DISTINCT FROM OLD.target_id
+        AND NEW.ip = ''
+        AND NEW.user_agent = ''
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
 
+CREATE TRIGGER audit_events_no_update_delete
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();